@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/abaw/createrepo-lite/createrepo"
+	"golang.org/x/net/context"
+)
+
+// version is reported by --version, release builds override it with
+// -ldflags "-X main.version=..."
+var version = "0.1.0-dev"
+
+const progName = "createrepo-lite"
+
+// command is a subcommand of createrepo-lite
+type command struct {
+	name string
+	// args is the synopsis of the positional arguments
+	args    string
+	summary string
+	// run executes the command with the arguments following its name and
+	// returns the exit code
+	run func(cmd *command, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"create", "DIR", "Create repodata for the RPMs in DIR.", runCreate},
+		{"update", "DIR", "Update the repodata of DIR, only parsing new and changed RPMs.", runUpdate},
+		{"verify", "DIR", "Verify the repodata of DIR against the files on disk.", runVerify},
+		{"query", "DIR [PATTERN...]", "Query the packages in the repodata of DIR.", runQuery},
+		{"repoclosure", "DIR", "Check that the requires of the packages in DIR resolve.", runRepoclosure},
+		{"merge", "DIR[=BASEURL]...", "Merge the repodata of several repositories into the output directory.", runMerge},
+		{"index-remote", "URL", "Create repodata for the RPMs at URL, only downloading their headers.", runIndexRemote},
+		{"sync", "SOURCE DIR", "Mirror the repository SOURCE, a directory or URL, into DIR.", runSync},
+		{"snapshot", "publish|list|rollback|gc ROOT [ARGS]", "Publish, list, roll back and garbage-collect snapshots below ROOT.", runSnapshot},
+		{"pool", "import|verify|gc POOL [ARGS]", "Store RPMs once in the content-addressable pool POOL and link them into repository trees.", runPool},
+		{"diff", "OLD NEW", "List the packages added, removed, upgraded and downgraded from OLD to NEW.", runDiff},
+		{"serve", "DIR", "Serve the repository DIR over HTTP.", runServe},
+		{"modifyrepo", "FILE REPODATA", "Add FILE to, or with --remove remove it from, the repodata directory REPODATA.", runModifyrepo},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [--help] [--version] COMMAND [options] [args]\n\nCommands:\n", progName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s COMMAND --help' for the options of a command.\n", progName)
+	fmt.Fprintf(w, "\nExit codes:\n")
+	fmt.Fprintf(w, "  %d  success\n", exitOK)
+	fmt.Fprintf(w, "  %d  failure\n", exitFailure)
+	fmt.Fprintf(w, "  %d  invalid command line\n", exitUsage)
+	fmt.Fprintf(w, "  %d  repodata generated, but some packages were skipped\n", exitPartial)
+}
+
+// run dispatches the command line, without the program name, to the command
+// and returns the exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				return cmd.run(cmd, []string{"--help"})
+			}
+		}
+		usage(os.Stdout)
+		return exitOK
+	case "-version", "--version", "version":
+		fmt.Printf("%s %s\n", progName, version)
+		return exitOK
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", progName, args[0])
+		usage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run(cmd, args[1:])
+}
+
+// newFlagSet returns the FlagSet for the options of cmd
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [options] %s\n\n%s\n\nOptions:\n", progName, cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses args with fs and checks that the number of positional
+// arguments is between min and max, a negative max meaning no limit. It
+// returns false with the exit code if the command should not go on.
+func parseArgs(fs *flag.FlagSet, args []string, min int, max int) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fmt.Fprintf(fs.Output(), "%s: wrong number of arguments\n", fs.Name())
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// stringList is a flag.Value collecting all occurrences of a flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// generateFlags are the options shared by create and update
+type generateFlags struct {
+	outputDir    string
+	srpmsDir     string
+	debugDir     string
+	arches       stringList
+	archLayout   string
+	compat       stringList
+	workers      int
+	checksumType string
+	compression  string
+	excludes     stringList
+	pkglist      string
+	null         bool
+	skipSymlinks bool
+	onError      string
+	maxFailures  int
+	timeout      time.Duration
+	retainCount  int
+	retainAge    time.Duration
+	prune        string
+	pruneDir     string
+	dryRun       bool
+	duplicates   string
+	watch        bool
+	debounce     time.Duration
+}
+
+func addGenerateFlags(fs *flag.FlagSet) *generateFlags {
+	f := generateFlags{}
+	fs.StringVar(&f.outputDir, "o", "", "write repodata/ into this directory instead of DIR")
+	fs.StringVar(&f.outputDir, "outputdir", "", "same as -o")
+	fs.StringVar(&f.srpmsDir, "srpms-dir", "", "write the repodata of source RPMs into this `directory`")
+	fs.StringVar(&f.debugDir, "debug-dir", "", "write the repodata of debuginfo/debugsource RPMs into this `directory`")
+	fs.Var(&f.arches, "arch", "write one repository per target `arch` instead of DIR/repodata, may be repeated or comma separated")
+	fs.StringVar(&f.archLayout, "arch-layout", "{arch}", "`path` of the repository of an arch relative to the output directory")
+	fs.Var(&f.compat, "compat", "multilib arches of a target, e.g. x86_64=i686,i586, may be repeated")
+	fs.IntVar(&f.workers, "workers", runtime.NumCPU(), "number of RPMs parsed concurrently")
+	fs.StringVar(&f.checksumType, "checksum", "sha256", "checksum type of packages and metadata: md5, sha1, sha224, sha256, sha384 or sha512")
+	fs.StringVar(&f.compression, "compress-type", "gz", "compression of the metadata: none, gz, xz or zstd")
+	fs.Var(&f.excludes, "exclude", "glob `pattern` of paths to skip, may be repeated")
+	fs.StringVar(&f.pkglist, "pkglist", "", "only index the RPMs listed in this `file`, - for stdin")
+	fs.BoolVar(&f.null, "null", false, "paths in --pkglist are separated by NUL instead of newline")
+	fs.BoolVar(&f.skipSymlinks, "skip-symlinks", false, "ignore symlinks to RPMs and directories")
+	fs.StringVar(&f.onError, "on-error", "skip", "what to do when a package fails: fail-fast, skip or max-failures")
+	fs.IntVar(&f.maxFailures, "max-failures", 10, "number of failures tolerated by --on-error=max-failures")
+	fs.DurationVar(&f.timeout, "timeout", 0, "abort if the metadata is not generated within this duration, e.g. 10m")
+	fs.IntVar(&f.retainCount, "retain-count", 0, "only keep the `N` highest versions of every package name and arch")
+	fs.DurationVar(&f.retainAge, "retain-age", 0, "also keep the builds younger than this `duration`, e.g. 720h")
+	fs.StringVar(&f.prune, "prune", "none", "what to do with the files of builds not retained: none, move or delete")
+	fs.StringVar(&f.pruneDir, "prune-dir", "", "`directory` the files are moved into by --prune=move")
+	fs.StringVar(&f.duplicates, "duplicates", "warn", "what to do with a NEVRA in several files: warn, error, keep-newest or keep-first")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "only list what would be pruned, write nothing")
+	fs.BoolVar(&f.watch, "watch", false, "keep running and update the repodata whenever RPMs are added, removed or replaced (linux only)")
+	fs.DurationVar(&f.debounce, "debounce", 2*time.Second, "with --watch, wait until nothing changed for this duration before updating")
+	return &f
+}
+
+// newGenerator returns the Generator of DIR configured by the options
+func (f *generateFlags) newGenerator(dir string) (*createrepo.Generator, error) {
+	options, err := f.options()
+	if err != nil {
+		return nil, err
+	}
+	return createrepo.NewGenerator(dir, options...)
+}
+
+// options returns the Generator options set by the flags
+func (f *generateFlags) options() ([]createrepo.Option, error) {
+	options := []createrepo.Option{
+		createrepo.WithWorkers(f.workers),
+		createrepo.WithChecksum(f.checksumType),
+		createrepo.WithCompression(f.compression),
+		createrepo.WithExcludes(f.excludes...),
+		createrepo.WithErrorPolicy(f.onError, f.maxFailures),
+		createrepo.WithFollowSymlinks(!f.skipSymlinks),
+		createrepo.WithRetention(f.retainCount, f.retainAge),
+		createrepo.WithPrune(f.prune, f.pruneDir),
+		createrepo.WithDuplicatePolicy(f.duplicates),
+		createrepo.WithDryRun(f.dryRun),
+	}
+	if f.outputDir != "" {
+		options = append(options, createrepo.WithOutputDir(f.outputDir))
+	}
+	if f.srpmsDir != "" {
+		options = append(options, createrepo.WithSourceRepo(f.srpmsDir))
+	}
+	if f.debugDir != "" {
+		options = append(options, createrepo.WithDebugRepo(f.debugDir))
+	}
+
+	if len(f.arches) != 0 {
+		for _, arches := range f.arches {
+			options = append(options, createrepo.WithArches(strings.Split(arches, ",")...))
+		}
+		options = append(options, createrepo.WithArchLayout(f.archLayout))
+	}
+	for _, entry := range f.compat {
+		target, compat, err := createrepo.ParseCompatArches(entry)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, createrepo.WithCompatArches(target, compat...))
+	}
+
+	if f.pkglist != "" {
+		paths, err := f.readPackageList()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, createrepo.WithPackageList(paths...))
+	}
+	return options, nil
+}
+
+// readPackageList reads the file given by --pkglist
+func (f *generateFlags) readPackageList() ([]string, error) {
+	var sep byte = '\n'
+	if f.null {
+		sep = 0
+	}
+
+	if f.pkglist == "-" {
+		return createrepo.ReadPackageList(os.Stdin, sep)
+	}
+
+	file, err := os.Open(f.pkglist)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return createrepo.ReadPackageList(file, sep)
+}
+
+// runGenerate implements create and update, which only differ in the method
+// of the Generator they call.
+func runGenerate(cmd *command, args []string, update bool) int {
+	fs := newFlagSet(cmd)
+	f := addGenerateFlags(fs)
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+
+	if f.watch && (f.dryRun || f.pkglist != "") {
+		fmt.Fprintf(os.Stderr, "%s: --watch cannot be used with --dry-run or --pkglist\n", cmd.name)
+		return exitUsage
+	}
+	g, err := f.newGenerator(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitUsage
+	}
+
+	ctx, cancel := newContext(f.timeout)
+	defer cancel()
+
+	var result *createrepo.Result
+	if update {
+		result, err = g.Update(ctx)
+	} else {
+		result, err = g.Generate(ctx)
+	}
+
+	for _, path := range result.Pruned {
+		switch {
+		case f.dryRun:
+			fmt.Printf("would prune %s\n", path)
+		case err == nil:
+			fmt.Printf("pruned %s\n", path)
+		}
+	}
+	result.WriteSummary(os.Stderr)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	case f.watch:
+		err = g.Watch(ctx, f.debounce, func(result *createrepo.Result, err error) {
+			if err == nil {
+				result.WriteSummary(os.Stderr)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+	case len(result.Failures) != 0:
+		return exitPartial
+	}
+	return exitOK
+}
+
+func runCreate(cmd *command, args []string) int {
+	return runGenerate(cmd, args, false)
+}
+
+func runUpdate(cmd *command, args []string) int {
+	return runGenerate(cmd, args, true)
+}
+
+func runModifyrepo(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	mdType := fs.String("mdtype", "", "type of the metadata, the file name up to the first dot by default")
+	remove := fs.Bool("remove", false, "remove the metadata FILE, a file name or a type, from REPODATA")
+	checksumType := fs.String("checksum", "sha256", "checksum type of the metadata")
+	compression := fs.String("compress-type", "gz", "compression of FILE unless it is already compressed: none, gz, xz or zstd")
+	if code, ok := parseArgs(fs, args, 2, 2); !ok {
+		return code
+	}
+
+	var err error
+	if *remove {
+		err = createrepo.RemoveMetadata(fs.Arg(1), fs.Arg(0))
+	} else {
+		err = createrepo.AddMetadata(fs.Arg(1), fs.Arg(0), *mdType, *checksumType, *compression)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+// writeVerifyReport prints the problems of report to stdout, as tab
+// separated lines or as JSON
+func writeVerifyReport(report *createrepo.VerifyReport, format string) {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("%s\t%s\t%s\n", p.Kind, p.Path, p.Detail)
+	}
+}
+
+func runVerify(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	baseDir := fs.String("basedir", "", "`directory` the locations of the packages are relative to, DIR by default")
+	format := fs.String("format", "text", "format of the report: text, one tab separated problem per line, or json")
+	timeout := fs.Duration("timeout", 0, "abort if the verification takes longer than this duration")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+	if *baseDir == "" {
+		*baseDir = fs.Arg(0)
+	}
+
+	ctx, cancel := newContext(*timeout)
+	defer cancel()
+
+	report, err := createrepo.Verify(ctx, fs.Arg(0), *baseDir)
+	if report != nil {
+		writeVerifyReport(report, *format)
+		fmt.Fprintf(os.Stderr, "checked %d metadata file(s) and %d package(s), %d problem(s)\n",
+			report.Metadata, report.Packages, len(report.Problems))
+		if report.Remote != 0 {
+			fmt.Fprintf(os.Stderr, "skipped %d package(s) with a remote location_base\n", report.Remote)
+		}
+	}
+
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	case !report.OK():
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runQuery(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	q := createrepo.Query{}
+	fs.StringVar(&q.WhatProvides, "whatprovides", "", "only packages providing this `capability` or file")
+	fs.StringVar(&q.WhatRequires, "whatrequires", "", "only packages requiring this `capability` or file")
+	fs.StringVar(&q.File, "file", "", "only packages containing this `path`")
+	fs.BoolVar(&q.Latest, "latest", false, "only the highest version of every package name and arch")
+	changelog := fs.Bool("changelog", false, "print the changelog of the packages")
+	format := fs.String("format", "nevra", "output format: nevra, one package per line, or json")
+	queryFormat := fs.String("queryformat", "", "print every package with this `template`, e.g. '%{name}-%{version}\\n'")
+	if code, ok := parseArgs(fs, args, 1, -1); !ok {
+		return code
+	}
+	q.Patterns = fs.Args()[1:]
+
+	if *format != "nevra" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+	var qf *createrepo.QueryFormat
+	var err error
+	if *queryFormat != "" {
+		if qf, err = createrepo.ParseQueryFormat(*queryFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitUsage
+		}
+	}
+
+	repo, err := createrepo.OpenRepo(fs.Arg(0))
+	if err == nil {
+		var packages []*createrepo.Package
+		if packages, err = repo.Query(q); err == nil {
+			err = printPackages(os.Stdout, packages, *format, qf, *changelog)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printPackages writes the result of a query to w
+func printPackages(w io.Writer, packages []*createrepo.Package, format string, qf *createrepo.QueryFormat, changelog bool) error {
+	if format == "json" {
+		list := make([]map[string]interface{}, len(packages))
+		for i, p := range packages {
+			list[i] = p.Fields()
+			if changelog {
+				list[i]["changelog"] = p.Changelog()
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+
+	for _, p := range packages {
+		switch {
+		case qf != nil:
+			fmt.Fprint(w, qf.Format(p))
+		case changelog:
+			fmt.Fprintf(w, "%s:\n", p)
+		default:
+			fmt.Fprintln(w, p)
+		}
+
+		if changelog {
+			for _, c := range p.Changelog() {
+				fmt.Fprintf(w, "* %s %s\n%s\n\n", c.Date.Format("Mon Jan 02 2006"), c.Author, c.Text)
+			}
+		}
+	}
+	return nil
+}
+
+func runRepoclosure(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	var lookaside stringList
+	fs.Var(&lookaside, "lookaside", "also resolve against the packages of this repository `DIR`, may be repeated")
+	format := fs.String("format", "text", "format of the report: text, one tab separated requires per line, or json")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+
+	repo, err := createrepo.OpenRepo(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	var lookasideRepos []*createrepo.Repo
+	for _, dir := range lookaside {
+		r, err := createrepo.OpenRepo(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+		lookasideRepos = append(lookasideRepos, r)
+	}
+
+	report := createrepo.CheckClosure(repo, lookasideRepos...)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, u := range report.Unresolved {
+			for _, r := range u.Requires {
+				fmt.Printf("%s\t%s\n", u.Package, r)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "checked %d package(s), %d with unresolved requires\n", report.Packages, len(report.Unresolved))
+
+	if !report.OK() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// parseMergeSource parses DIR[=BASEURL]. A "=" only separates the URL if
+// one follows it, so that it may appear in DIR.
+func parseMergeSource(arg string) createrepo.MergeSource {
+	if i := strings.Index(arg, "="); i >= 0 && strings.Contains(arg[i+1:], "://") {
+		return createrepo.MergeSource{Dir: arg[:i], BaseURL: arg[i+1:]}
+	}
+	return createrepo.MergeSource{Dir: arg}
+}
+
+func runMerge(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	var outputDir string
+	fs.StringVar(&outputDir, "o", "", "write the merged repodata/ into this `directory`, required")
+	fs.StringVar(&outputDir, "outputdir", "", "same as -o")
+	options := createrepo.MergeOptions{}
+	fs.StringVar(&options.Policy, "policy", "first", "which packages of a NEVRA in several repositories are kept: first, newest or all")
+	fs.StringVar(&options.ChecksumType, "checksum", "sha256", "checksum type of the metadata")
+	fs.StringVar(&options.Compression, "compress-type", "gz", "compression of the metadata: none, gz, xz or zstd")
+	timeout := fs.Duration("timeout", 0, "abort if the merge takes longer than this duration")
+	if code, ok := parseArgs(fs, args, 1, -1); !ok {
+		return code
+	}
+	if outputDir == "" {
+		fmt.Fprintf(os.Stderr, "%s: -o is required\n", cmd.name)
+		return exitUsage
+	}
+
+	var sources []createrepo.MergeSource
+	for _, arg := range fs.Args() {
+		sources = append(sources, parseMergeSource(arg))
+	}
+
+	ctx, cancel := newContext(*timeout)
+	defer cancel()
+
+	result, err := createrepo.Merge(ctx, outputDir, sources, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	result.WriteSummary(os.Stderr)
+	fmt.Fprintf(os.Stderr, "merged %d package(s) from %d repositories\n", result.Packages, len(sources))
+	return exitOK
+}
+
+// openLocation opens a local file or, if location is a HTTP URL, the body
+// of its response
+func openLocation(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("GET %s: %s", location, resp.Status))
+	}
+	return resp.Body, nil
+}
+
+func runIndexRemote(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	var outputDir string
+	fs.StringVar(&outputDir, "o", "", "write repodata/ into this `directory`, required")
+	fs.StringVar(&outputDir, "outputdir", "", "same as -o")
+	checksums := fs.String("checksums", "", "sha256sum style list of the RPMs, a `file or URL`, giving their pkgId")
+	pkglist := fs.String("pkglist", "", "only index the RPMs listed in this `file`, relative to URL")
+	options := createrepo.RemoteOptions{}
+	fs.BoolVar(&options.HeaderDigest, "header-digest", false, "use the header digest as pkgId of RPMs missing from --checksums")
+	fs.IntVar(&options.Workers, "workers", runtime.NumCPU(), "number of RPMs fetched concurrently")
+	fs.StringVar(&options.ChecksumType, "checksum", "sha256", "checksum type of the metadata")
+	fs.StringVar(&options.Compression, "compress-type", "gz", "compression of the metadata: none, gz, xz or zstd")
+	timeout := fs.Duration("timeout", 0, "abort if indexing takes longer than this duration")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if outputDir == "" || (*checksums == "" && *pkglist == "") {
+		fmt.Fprintf(os.Stderr, "%s: -o and at least one of --checksums and --pkglist are required\n", cmd.name)
+		return exitUsage
+	}
+
+	if *checksums != "" {
+		in, err := openLocation(*checksums)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+		options.Checksums, err = createrepo.ReadChecksums(in)
+		in.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", cmd.name, *checksums, err.Error())
+			return exitFailure
+		}
+	}
+	if *pkglist != "" {
+		file, err := os.Open(*pkglist)
+		if err == nil {
+			options.Packages, err = createrepo.ReadPackageList(file, '\n')
+			file.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+	}
+
+	ctx, cancel := newContext(*timeout)
+	defer cancel()
+
+	result, err := createrepo.IndexRemote(ctx, fs.Arg(0), outputDir, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	result.WriteSummary(os.Stderr)
+	fmt.Fprintf(os.Stderr, "indexed %d package(s), %d bytes downloaded\n", result.Indexed, result.Fetched)
+	if len(result.Failures) != 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+func runSync(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	options := createrepo.SyncOptions{}
+	fs.BoolVar(&options.Delete, "delete", false, "delete the RPMs of DIR which are gone from SOURCE")
+	fs.StringVar(&options.Metadata, "metadata", "copy", "copy the repodata of SOURCE, or regenerate it")
+	fs.IntVar(&options.Workers, "workers", 4, "number of concurrent downloads")
+	fs.BoolVar(&options.Verify, "verify", false, "hash every package of DIR, not only those whose size or time differ")
+	timeout := fs.Duration("timeout", 0, "abort if the sync takes longer than this duration")
+	if code, ok := parseArgs(fs, args, 2, 2); !ok {
+		return code
+	}
+	if options.Metadata != "copy" && options.Metadata != "regenerate" {
+		fmt.Fprintf(os.Stderr, "%s: unknown metadata mode %q\n", cmd.name, options.Metadata)
+		return exitUsage
+	}
+
+	ctx, cancel := newContext(*timeout)
+	defer cancel()
+
+	result, err := createrepo.Sync(ctx, fs.Arg(0), fs.Arg(1), options)
+	if result != nil {
+		result.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "downloaded %d package(s), %d bytes, %d up to date, %d deleted\n",
+		len(result.Downloaded), result.Fetched, result.UpToDate, len(result.Deleted))
+	if len(result.Failures) != 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+// snapshotCommands are the subcommands of snapshot
+var snapshotCommands = []*command{
+	{"snapshot publish", "ROOT DIR", "Publish the RPMs of DIR as a new snapshot below ROOT and make it the latest.", runSnapshotPublish},
+	{"snapshot list", "ROOT", "List the snapshots below ROOT, oldest first.", runSnapshotList},
+	{"snapshot rollback", "ROOT [NAME]", "Make the snapshot NAME, or the one before the latest, the latest.", runSnapshotRollback},
+	{"snapshot gc", "ROOT", "Remove the snapshots below ROOT which are not retained.", runSnapshotGC},
+}
+
+func runSnapshot(cmd *command, args []string) int {
+	return runGroup(cmd, snapshotCommands, args)
+}
+
+func runSnapshotPublish(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	f := addGenerateFlags(fs)
+	if code, ok := parseArgs(fs, args, 2, 2); !ok {
+		return code
+	}
+	if f.outputDir != "" || f.srpmsDir != "" || f.debugDir != "" || f.pkglist != "" || f.dryRun || f.watch {
+		fmt.Fprintf(os.Stderr, "%s: -o, --srpms-dir, --debug-dir, --pkglist, --dry-run and --watch cannot be used\n", cmd.name)
+		return exitUsage
+	}
+	options, err := f.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitUsage
+	}
+
+	ctx, cancel := newContext(f.timeout)
+	defer cancel()
+
+	snapshot, result, err := createrepo.PublishSnapshot(ctx, fs.Arg(0), fs.Arg(1), options...)
+	if result != nil {
+		result.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	fmt.Println(snapshot.Name)
+	fmt.Fprintf(os.Stderr, "published %s: %d package(s), %d reused\n", snapshot.Path, result.Indexed, result.Reused)
+	if len(result.Failures) != 0 {
+		return exitPartial
+	}
+	return exitOK
+}
+
+func runSnapshotList(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	format := fs.String("format", "text", "format of the list: text or json")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+
+	snapshots, err := createrepo.ListSnapshots(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(snapshots)
+		return exitOK
+	}
+	for _, s := range snapshots {
+		latest := ""
+		if s.Latest {
+			latest = " (latest)"
+		}
+		fmt.Printf("%s\t%s%s\n", s.Name, s.Time.Format(time.RFC3339), latest)
+	}
+	return exitOK
+}
+
+func runSnapshotRollback(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	if code, ok := parseArgs(fs, args, 1, 2); !ok {
+		return code
+	}
+
+	snapshot, err := createrepo.Rollback(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "latest is now %s\n", snapshot.Name)
+	return exitOK
+}
+
+func runSnapshotGC(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	keep := fs.Int("keep", 0, "keep the `N` newest snapshots")
+	maxAge := fs.Duration("max-age", 0, "keep the snapshots younger than this `duration`, e.g. 720h")
+	dryRun := fs.Bool("dry-run", false, "only list the snapshots which would be removed")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if *keep <= 0 && *maxAge <= 0 {
+		fmt.Fprintf(os.Stderr, "%s: at least one of --keep and --max-age is required\n", cmd.name)
+		return exitUsage
+	}
+
+	removed, err := createrepo.GCSnapshots(fs.Arg(0), *keep, *maxAge, *dryRun)
+	for _, s := range removed {
+		if *dryRun {
+			fmt.Printf("would remove %s\n", s.Name)
+		} else {
+			fmt.Printf("removed %s\n", s.Name)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+// poolCommands are the subcommands of pool
+var poolCommands = []*command{
+	{"pool import", "POOL DIR...", "Add the RPMs below each DIR to POOL and replace them by links to it.", runPoolImport},
+	{"pool verify", "POOL", "Check that every object of POOL matches its sha256.", runPoolVerify},
+	{"pool gc", "POOL [TREE...]", "Remove the objects of POOL which are neither hard linked nor symlinked by import or from a TREE.", runPoolGC},
+}
+
+// runGroup runs the subcommand of cmd named by args[0], or prints the
+// subcommands for --help
+func runGroup(cmd *command, subs []*command, args []string) int {
+	var names []string
+	for _, sub := range subs {
+		if len(args) != 0 && sub.name == cmd.name+" "+args[0] {
+			return sub.run(sub, args[1:])
+		}
+		names = append(names, strings.TrimPrefix(sub.name, cmd.name+" "))
+	}
+	if len(args) == 0 || (args[0] != "-h" && args[0] != "--help") {
+		fmt.Fprintf(os.Stderr, "%s: expected one of %s\n", cmd.name, strings.Join(names, ", "))
+		return exitUsage
+	}
+	fmt.Printf("Usage: %s %s\n\n%s\n\n", progName, cmd.name+" "+cmd.args, cmd.summary)
+	for _, sub := range subs {
+		fmt.Printf("  %-30s %s\n", sub.name+" "+sub.args, sub.summary)
+	}
+	return exitOK
+}
+
+func runPool(cmd *command, args []string) int {
+	return runGroup(cmd, poolCommands, args)
+}
+
+func runPoolImport(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	link := fs.String("link", "hard", "replace the RPMs by a hard link or a symlink to the pool, or none to keep them")
+	if code, ok := parseArgs(fs, args, 2, -1); !ok {
+		return code
+	}
+	if *link != "hard" && *link != "symlink" && *link != "none" {
+		fmt.Fprintf(os.Stderr, "%s: unknown link mode %q\n", cmd.name, *link)
+		return exitUsage
+	}
+	pool, err := createrepo.OpenPool(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+
+	ctx, cancel := newContext(0)
+	defer cancel()
+
+	code := exitOK
+	for _, dir := range fs.Args()[1:] {
+		result, err := pool.Import(ctx, dir, *link)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+		(&createrepo.Result{Failures: result.Failures}).WriteSummary(os.Stderr)
+		fmt.Fprintf(os.Stderr, "%s: %d imported, %d deduplicated, %d bytes saved\n", dir, result.Imported, result.Deduplicated, result.Saved)
+		if len(result.Failures) != 0 {
+			code = exitPartial
+		}
+	}
+	return code
+}
+
+func runPoolVerify(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	format := fs.String("format", "text", "format of the report: text, one tab separated problem per line, or json")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+	// unlike import, they must not create the pool
+	pool, err := createrepo.OpenExistingPool(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+
+	ctx, cancel := newContext(0)
+	defer cancel()
+
+	report, err := pool.Verify(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	writeVerifyReport(report, *format)
+	fmt.Fprintf(os.Stderr, "checked %d object(s), %d problem(s)\n", report.Packages, len(report.Problems))
+	if !report.OK() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runPoolGC(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	dryRun := fs.Bool("dry-run", false, "only list the objects which would be removed")
+	if code, ok := parseArgs(fs, args, 1, -1); !ok {
+		return code
+	}
+	// unlike import, they must not create the pool
+	pool, err := createrepo.OpenExistingPool(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+
+	ctx, cancel := newContext(0)
+	defer cancel()
+
+	removed, size, err := pool.GC(ctx, fs.Args()[1:], *dryRun)
+	for _, path := range removed {
+		if *dryRun {
+			fmt.Printf("would remove %s\n", path)
+		} else {
+			fmt.Printf("removed %s\n", path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "%d object(s), %d bytes\n", len(removed), size)
+	return exitOK
+}
+
+func runDiff(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	format := fs.String("format", "text", "format of the report: text, json or markdown")
+	if code, ok := parseArgs(fs, args, 2, 2); !ok {
+		return code
+	}
+	if *format != "text" && *format != "json" && *format != "markdown" {
+		fmt.Fprintf(os.Stderr, "%s: unknown format %q\n", cmd.name, *format)
+		return exitUsage
+	}
+
+	var repos []*createrepo.Repo
+	for _, dir := range fs.Args() {
+		repo, err := createrepo.OpenRepo(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+		repos = append(repos, repo)
+	}
+
+	report := createrepo.Diff(repos[0], repos[1])
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case "markdown":
+		report.WriteMarkdown(os.Stdout)
+	default:
+		report.WriteText(os.Stdout)
+	}
+	fmt.Fprintf(os.Stderr, "%d added, %d removed, %d upgraded, %d downgraded, %d rebuilt\n",
+		len(report.Added), len(report.Removed), len(report.Upgraded), len(report.Downgraded), len(report.Rebuilt))
+	return exitOK
+}
+
+func runServe(cmd *command, args []string) int {
+	fs := newFlagSet(cmd)
+	f := addGenerateFlags(fs)
+	addr := fs.String("addr", ":8080", "`address` to listen on")
+	update := fs.Bool("update", false, "update the repodata before serving")
+	uploads := fs.Bool("uploads", false, "accept RPMs uploaded by PUT or POST to their path, and update the repodata")
+	maxUpload := fs.Int64("max-upload", createrepo.DefaultMaxUploadSize, "largest RPM accepted in `bytes`")
+	if code, ok := parseArgs(fs, args, 1, 1); !ok {
+		return code
+	}
+	if f.outputDir != "" || f.pkglist != "" || f.dryRun {
+		fmt.Fprintf(os.Stderr, "%s: -o, --pkglist and --dry-run cannot be used\n", cmd.name)
+		return exitUsage
+	}
+	dir := fs.Arg(0)
+
+	var g *createrepo.Generator
+	var err error
+	if *update || *uploads || f.watch {
+		var options []createrepo.Option
+		if options, err = f.options(); err == nil {
+			if *uploads {
+				options = append(options, createrepo.WithUploads())
+			}
+			g, err = createrepo.NewGenerator(dir, options...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitUsage
+		}
+	}
+
+	ctx, cancel := newContext(0)
+	defer cancel()
+
+	if *update {
+		result, err := g.Update(ctx)
+		result.WriteSummary(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+	}
+
+	server := createrepo.NewServer(dir, nil)
+	if *uploads {
+		server = createrepo.NewServer(dir, g)
+	}
+	server.MaxUploadSize = *maxUpload
+
+	watchErrs := make(chan error, 1)
+	if f.watch {
+		go func() {
+			watchErrs <- g.Watch(ctx, f.debounce, nil)
+			cancel()
+		}()
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: server}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving %s on %s\n", dir, *addr)
+	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+		return exitFailure
+	}
+
+	select {
+	case err = <-watchErrs:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err.Error())
+			return exitFailure
+		}
+	default:
+	}
+	return exitOK
+}
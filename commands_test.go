@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"frobnicate"}, exitUsage},
+		{[]string{"--version"}, exitOK},
+		{[]string{"create", "--help"}, exitOK},
+		{[]string{"create"}, exitUsage},
+		{[]string{"create", "--checksum", "crc32", "."}, exitUsage},
+		{[]string{"create", "--compress-type", "bz2", "."}, exitUsage},
+		{[]string{"update", "--watch", "--dry-run", "."}, exitUsage},
+		{[]string{"verify", "--format", "xml", "."}, exitUsage},
+		{[]string{"verify", "/nonexistent"}, exitFailure},
+		{[]string{"repoclosure", "--format", "xml", "."}, exitUsage},
+		{[]string{"repoclosure", "/nonexistent"}, exitFailure},
+		{[]string{"merge", "."}, exitUsage},
+		{[]string{"merge", "-o", "/nonexistent/out", "--policy", "last", "."}, exitFailure},
+		{[]string{"merge", "-o", "/nonexistent/out", "/nonexistent"}, exitFailure},
+		{[]string{"index-remote", "-o", "/tmp", "http://localhost/"}, exitUsage},
+		{[]string{"index-remote", "-o", "/tmp", "--checksums", "/nonexistent", "http://localhost/"}, exitFailure},
+		{[]string{"sync", "."}, exitUsage},
+		{[]string{"sync", "--metadata", "link", ".", "/tmp"}, exitUsage},
+		{[]string{"sync", "ftp://mirror/os", "/tmp"}, exitFailure},
+		{[]string{"snapshot"}, exitUsage},
+		{[]string{"snapshot", "--help"}, exitOK},
+		{[]string{"snapshot", "publish", "--dry-run", "/tmp", "."}, exitUsage},
+		{[]string{"snapshot", "list", "/nonexistent"}, exitFailure},
+		{[]string{"snapshot", "rollback", "/nonexistent"}, exitFailure},
+		{[]string{"snapshot", "gc", "/tmp"}, exitUsage},
+		{[]string{"pool", "dedupe"}, exitUsage},
+		{[]string{"pool", "import", "/tmp/pool"}, exitUsage},
+		{[]string{"pool", "import", "--link", "copy", "/tmp/pool", "."}, exitUsage},
+		{[]string{"pool", "verify", "/nonexistent"}, exitFailure},
+		{[]string{"diff", "."}, exitUsage},
+		{[]string{"serve", "-o", "/tmp", "."}, exitUsage},
+		{[]string{"serve", "--addr", "invalid address", "."}, exitFailure},
+		{[]string{"diff", "--format", "html", ".", "."}, exitUsage},
+		{[]string{"diff", "/nonexistent", "."}, exitFailure},
+	}
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, _ = os.Open(os.DevNull)
+	os.Stderr = os.Stdout
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+	}()
+
+	for _, c := range cases {
+		if code := run(c.args); code != c.expected {
+			t.Errorf("run(%v) = %d, expected %d", c.args, code, c.expected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "path/filepath"
+import "strings"
+
+// archPlaceholder is replaced by the architecture in the layout of
+// per-architecture repositories
+const archPlaceholder = "{arch}"
+
+// defaultArchLayout puts the repository of an architecture into
+// <outputDir>/<arch>
+const defaultArchLayout = archPlaceholder
+
+// defaultCompatArches lists for a target architecture the multilib
+// architectures whose packages are included in its repository as well
+var defaultCompatArches = map[string][]string{
+	"x86_64":  {"i686", "i586", "i486", "i386", "athlon"},
+	"ppc64":   {"ppc"},
+	"ppc64le": {},
+	"s390x":   {"s390"},
+	"aarch64": {},
+}
+
+// checkArchLayout returns an error if layout cannot be used for
+// per-architecture repositories
+func checkArchLayout(layout string) error {
+	if !strings.Contains(layout, archPlaceholder) {
+		return errors.New(fmt.Sprintf("arch layout %q does not contain %s", layout, archPlaceholder))
+	}
+	if filepath.IsAbs(layout) {
+		return errors.New(fmt.Sprintf("arch layout %q is not relative to the output directory", layout))
+	}
+	return nil
+}
+
+// archDir returns the directory of the repository of arch
+func (repo *repository) archDir(arch string) string {
+	layout := repo.archLayout
+	if layout == "" {
+		layout = defaultArchLayout
+	}
+	return filepath.Join(repo.outputDir, strings.Replace(layout, archPlaceholder, arch, -1))
+}
+
+// compatible returns true if packages of arch belong into the repository of
+// the target architecture
+func (repo *repository) compatible(target string, arch string) bool {
+	if arch == target || arch == "noarch" {
+		return true
+	}
+
+	compat, ok := repo.compatArches[target]
+	if !ok {
+		compat = defaultCompatArches[target]
+	}
+	for _, a := range compat {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// archDirs returns the directories of the per-architecture repositories
+// listing p
+func (repo *repository) archDirs(p *packageInfo) []string {
+	var dirs []string
+	for _, target := range repo.arches {
+		if p.isSource() || repo.compatible(target, p.rpmArch) {
+			dirs = append(dirs, repo.archDir(target))
+		}
+	}
+	return dirs
+}
+
+// ParseCompatArches parses a compat table entry of the form
+// "x86_64=i686,i586" for WithCompatArches. An empty list, "x86_64=",
+// disables multilib for the target.
+func ParseCompatArches(entry string) (string, []string, error) {
+	i := strings.Index(entry, "=")
+	if i <= 0 {
+		return "", nil, errors.New(fmt.Sprintf("invalid compat arches %q, expected TARGET=ARCH,...", entry))
+	}
+
+	compat := []string{}
+	for _, arch := range strings.Split(entry[i+1:], ",") {
+		if arch = strings.TrimSpace(arch); arch != "" {
+			compat = append(compat, arch)
+		}
+	}
+	return entry[:i], compat, nil
+}
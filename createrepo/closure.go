@@ -0,0 +1,106 @@
+package createrepo
+
+import "strings"
+
+// Unresolved is a package with requires no package of the closure provides
+type Unresolved struct {
+	Package  string   `json:"package"`
+	Requires []string `json:"requires"`
+}
+
+// ClosureReport is the outcome of CheckClosure
+type ClosureReport struct {
+	// Packages is the number of packages checked
+	Packages   int          `json:"packages"`
+	Unresolved []Unresolved `json:"unresolved"`
+}
+
+// OK returns true if every requires resolved
+func (r *ClosureReport) OK() bool {
+	return len(r.Unresolved) == 0
+}
+
+// provider is a package offering a capability or a file
+type provider struct {
+	pkg *Package
+	// provide is nil for files
+	provide *dependency
+}
+
+// closureIndex maps capabilities and files to their providers
+type closureIndex map[string][]provider
+
+func (index closureIndex) add(packages []*Package) {
+	for _, p := range packages {
+		for i := range p.info.provides {
+			d := &p.info.provides[i]
+			index[d.name] = append(index[d.name], provider{p, d})
+		}
+		for _, f := range p.info.files {
+			index[f.name] = append(index[f.name], provider{p, nil})
+		}
+	}
+}
+
+// CheckClosure checks that every requires of the binary packages of repo
+// is provided by a package of repo or of one of the lookaside repositories,
+// taking versions and architectures into account. Rich dependencies, e.g.
+// "(foo or bar)", are not evaluated and are considered resolved.
+func CheckClosure(repo *Repo, lookaside ...*Repo) *ClosureReport {
+	index := make(closureIndex)
+	index.add(repo.packages)
+	for _, r := range lookaside {
+		index.add(r.packages)
+	}
+
+	report := ClosureReport{Unresolved: []Unresolved{}}
+	for _, p := range repo.packages {
+		if p.info.isSource() {
+			continue
+		}
+		report.Packages++
+
+		var missing []string
+		for _, r := range p.info.requires {
+			if !strings.HasPrefix(r.name, "(") && !index.resolves(p, r) {
+				missing = append(missing, r.String())
+			}
+		}
+		if missing != nil {
+			report.Unresolved = append(report.Unresolved, Unresolved{p.String(), missing})
+		}
+	}
+	return &report
+}
+
+// resolves returns true if a package compatible with p satisfies r
+func (index closureIndex) resolves(p *Package, r dependency) bool {
+	for _, candidate := range index[r.name] {
+		if !archesCompatible(p.Arch, candidate.pkg.Arch) {
+			continue
+		}
+		if candidate.provide == nil || candidate.provide.satisfies(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// archesCompatible returns true if a package of arch a may depend on one of
+// arch b: the same arch, noarch on either side or multilib compat arches
+func archesCompatible(a string, b string) bool {
+	if a == b || a == "noarch" || b == "noarch" {
+		return true
+	}
+	for _, compat := range defaultCompatArches[a] {
+		if compat == b {
+			return true
+		}
+	}
+	for _, compat := range defaultCompatArches[b] {
+		if compat == a {
+			return true
+		}
+	}
+	return false
+}
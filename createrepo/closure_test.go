@@ -0,0 +1,107 @@
+package createrepo
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestDependencySatisfies(t *testing.T) {
+	dep := func(s string) dependency {
+		fields := strings.Fields(s)
+		if len(fields) == 1 {
+			return dependency{name: fields[0]}
+		}
+		flags := map[string]string{"=": "EQ", "<": "LT", "<=": "LE", ">": "GT", ">=": "GE"}[fields[1]]
+		epoch, version, release := splitEVR(fields[2])
+		return dependency{name: fields[0], flags: flags, epoch: epoch, version: version, release: release}
+	}
+
+	tests := []struct {
+		provide, require string
+		expected         bool
+	}{
+		{"foo", "foo >= 2.0", true},
+		{"foo = 1.0-1", "foo", true},
+		{"foo = 1.0-1", "foo >= 1.0", true},
+		{"foo = 1.0-1", "foo > 1.0", false},
+		{"foo = 1.0-1", "foo > 1.0-0", true},
+		{"foo = 1.0-1", "foo < 1.0-2", true},
+		{"foo = 1.0-1", "foo = 1.0", true},
+		{"foo = 1.0-1", "foo = 1.0-2", false},
+		{"foo = 1:0.9-1", "foo >= 1.0", true},
+		{"foo = 0.9-1", "foo >= 1.0", false},
+		{"foo >= 2.0", "foo < 1.0", false},
+		{"foo >= 2.0", "foo > 3.0", true},
+		{"foo <= 2.0", "foo >= 2.0", true},
+		{"foo < 2.0", "foo >= 2.0", false},
+		{"foo = 1.0~rc1-1", "foo >= 1.0", false},
+	}
+	for _, test := range tests {
+		if ok := dep(test.provide).satisfies(dep(test.require)); ok != test.expected {
+			t.Errorf("%q satisfies %q = %v, expected %v", test.provide, test.require, ok, test.expected)
+		}
+	}
+
+	shouldEqualStr(t, "String()", dep("foo >= 1:2.0-3").String(), "foo >= 1:2.0-3")
+}
+
+func TestCheckClosure(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	repo, err := OpenRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := CheckClosure(repo)
+	if report.Packages != 1 || len(report.Unresolved) != 1 {
+		t.Fatalf("expected openssl to have unresolved requires, got %+v", report)
+	}
+	unresolved := strings.Join(report.Unresolved[0].Requires, "\n")
+	for _, r := range []string{"/bin/sh", "ca-certificates >= 2008-5", "libc.so.6()(64bit)"} {
+		if !strings.Contains(unresolved, r+"\n") {
+			t.Errorf("%s should be unresolved", r)
+		}
+	}
+	for _, r := range []string{"config(openssl)", "libssl.so.10()(64bit)"} {
+		if strings.Contains(unresolved, r) {
+			t.Errorf("%s is provided by openssl itself", r)
+		}
+	}
+
+	// a lookaside repository providing everything, but ca-certificates is
+	// too old and make has the wrong arch
+	lookaside := &Repo{}
+	for _, r := range repo.packages[0].info.requires {
+		arch := "x86_64"
+		switch r.name {
+		case "ca-certificates":
+			r.flags, r.version, r.release = "EQ", "2008", "4"
+		case "make":
+			arch = "aarch64"
+		}
+		info := &packageInfo{rpmName: "dep", rpmArch: arch, provides: []dependency{r}}
+		if strings.HasPrefix(r.name, "/") {
+			info.provides = nil
+			info.files = []packageFile{{r.name, "file"}}
+		}
+		lookaside.packages = append(lookaside.packages, &Package{info.nevra(), info, ""})
+	}
+
+	report = CheckClosure(repo, lookaside)
+	if len(report.Unresolved) != 1 {
+		t.Fatalf("expected unresolved requires, got %+v", report)
+	}
+	shouldEqualStr(t, "unresolved", strings.Join(report.Unresolved[0].Requires, ", "), "ca-certificates >= 2008-5, make")
+}
@@ -0,0 +1,179 @@
+package createrepo
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressor describes a compression usable for metadata files
+type compressor struct {
+	// suffix is appended to the name of compressed files
+	suffix string
+	// magic starts the compressed data, nil for "none"
+	magic []byte
+	// newWriter returns a WriteCloser compressing into w, nil if files can
+	// only be read in this format
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressors are the supported compressions indexed by name
+var compressors = map[string]compressor{
+	"none": {
+		suffix: "",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	},
+	"gz": {
+		suffix: ".gz",
+		magic:  []byte{0x1f, 0x8b},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"bz2": {
+		suffix: ".bz2",
+		magic:  []byte("BZh"),
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+	"xz": {
+		suffix: ".xz",
+		magic:  []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(xr), nil
+		},
+	},
+	"zstd": {
+		suffix: ".zst",
+		magic:  []byte{0x28, 0xb5, 0x2f, 0xfd},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+}
+
+// getCompressor returns the compressor able to write the given compression
+func getCompressor(compression string) (compressor, error) {
+	c, ok := compressors[compression]
+	if !ok || c.newWriter == nil {
+		return compressor{}, errors.New(fmt.Sprintf("unsupported compression: %s", compression))
+	}
+	return c, nil
+}
+
+// compressorOf returns the compressor matching the suffix of path, the
+// "none" compressor if no known suffix matches.
+func compressorOf(path string) compressor {
+	for _, c := range compressors {
+		if c.suffix != "" && strings.HasSuffix(path, c.suffix) {
+			return c
+		}
+	}
+	return compressors["none"]
+}
+
+type decompressedFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (f decompressedFile) Close() error {
+	f.ReadCloser.Close()
+	return f.file.Close()
+}
+
+// openDecompressed opens the file at path and decompresses it according to
+// its suffix.
+func openDecompressed(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := compressorOf(path).newReader(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
+	}
+	return decompressedFile{r, file}, nil
+}
+
+// compressFile compresses src into the same directory and returns the path
+// of the compressed file. src is removed unless the compression is "none".
+func compressFile(src string, compression string) (string, error) {
+	c, err := getCompressor(compression)
+	if err != nil {
+		return "", err
+	}
+	if c.suffix == "" {
+		return src, nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	dst := src + c.suffix
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	w, err := c.newWriter(out)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	if err = out.Close(); err != nil {
+		return "", err
+	}
+
+	return dst, os.Remove(src)
+}
@@ -0,0 +1,42 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "<metadata packages=\"0\"/>\n"
+	for _, compression := range []string{"none", "gz", "xz", "zstd"} {
+		src := filepath.Join(dir, "other-"+compression+".xml")
+		if err = ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dst, err := compressFile(src, compression)
+		if err != nil {
+			t.Error("compressFile", compression, "failed:", err.Error())
+			continue
+		}
+
+		r, err := openDecompressed(dst)
+		if err != nil {
+			t.Error("openDecompressed", dst, "failed:", err.Error())
+			continue
+		}
+		decompressed, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Error("reading", dst, "failed:", err.Error())
+		}
+		shouldEqualStr(t, "decompressed "+compression, string(decompressed), content)
+	}
+}
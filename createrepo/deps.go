@@ -0,0 +1,288 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "path"
+import "sort"
+import "strings"
+
+// sense flags of a dependency, see rpmds.h
+const (
+	rpmsenseLess       = 1 << 1
+	rpmsenseGreater    = 1 << 2
+	rpmsenseEqual      = 1 << 3
+	rpmsensePrereq     = 1 << 6
+	rpmsenseScriptPre  = 1 << 9
+	rpmsenseScriptPost = 1 << 10
+	rpmsenseRpmlib     = 1 << 24
+
+	rpmfileGhost = 1 << 6
+)
+
+// dependency is an entry of the provides, requires, conflicts or obsoletes
+// of a package. flags is EQ, LT, LE, GT, GE or empty if there is no version.
+type dependency struct {
+	name    string
+	flags   string
+	epoch   string
+	version string
+	release string
+	// pre is true for requires needed by the scriptlets
+	pre bool
+}
+
+// packageFile is a file of a package. typ is "file", "dir" or "ghost".
+type packageFile struct {
+	name string
+	typ  string
+}
+
+// changelog is an entry of the changelog of a package
+type changelog struct {
+	author string
+	date   uint32
+	text   string
+}
+
+// senseFlags converts rpm sense flags to the names used by the metadata
+func senseFlags(sense uint64) string {
+	switch sense & (rpmsenseLess | rpmsenseGreater | rpmsenseEqual) {
+	case rpmsenseEqual:
+		return "EQ"
+	case rpmsenseLess:
+		return "LT"
+	case rpmsenseLess | rpmsenseEqual:
+		return "LE"
+	case rpmsenseGreater:
+		return "GT"
+	case rpmsenseGreater | rpmsenseEqual:
+		return "GE"
+	}
+	return ""
+}
+
+// splitEVR splits [epoch:]version[-release]
+func splitEVR(evr string) (string, string, string) {
+	var epoch, release string
+	if i := strings.Index(evr, ":"); i >= 0 {
+		epoch, evr = evr[:i], evr[i+1:]
+	}
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		evr, release = evr[:i], evr[i+1:]
+	}
+	return epoch, evr, release
+}
+
+// readDependencies reads the dependencies of the given kind, e.g. "require",
+// from hdr. rpmlib() requires are left out like createrepo does.
+func (hdr *rpmheader) readDependencies(kind string) ([]dependency, error) {
+	names, err := hdr.getStrings(kind + "name")
+	if err != nil {
+		return nil, err
+	}
+	flags, err := hdr.getNumbers(kind + "flags")
+	if err != nil {
+		return nil, err
+	}
+	versions, err := hdr.getStrings(kind + "version")
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) != len(names) || len(versions) != len(names) {
+		return nil, errors.New(fmt.Sprintf("inconsistent %s tags in %s", kind, hdr.path))
+	}
+
+	var deps []dependency
+	seen := make(map[dependency]bool)
+	for i, name := range names {
+		if kind == "require" && (flags[i]&rpmsenseRpmlib != 0 || strings.HasPrefix(name, "rpmlib(")) {
+			continue
+		}
+
+		d := dependency{name: name, flags: senseFlags(flags[i])}
+		if versions[i] != "" {
+			d.epoch, d.version, d.release = splitEVR(versions[i])
+			// like createrepo, a versioned dependency always has an epoch
+			if d.epoch == "" {
+				d.epoch = "0"
+			}
+		}
+		if kind == "require" {
+			d.pre = flags[i]&(rpmsensePrereq|rpmsenseScriptPre|rpmsenseScriptPost) != 0
+		}
+		if !seen[d] {
+			seen[d] = true
+			deps = append(deps, d)
+		}
+	}
+	return deps, nil
+}
+
+// readFiles reads the file list of hdr, sorted by name
+func (hdr *rpmheader) readFiles() ([]packageFile, error) {
+	basenames, err := hdr.getStrings("basenames")
+	if err != nil {
+		return nil, err
+	}
+	dirnames, err := hdr.getStrings("dirnames")
+	if err != nil {
+		return nil, err
+	}
+	dirindexes, err := hdr.getNumbers("dirindexes")
+	if err != nil {
+		return nil, err
+	}
+	modes, err := hdr.getNumbers("filemodes")
+	if err != nil {
+		return nil, err
+	}
+	fileflags, err := hdr.getNumbers("fileflags")
+	if err != nil {
+		return nil, err
+	}
+	if len(dirindexes) != len(basenames) || len(modes) != len(basenames) || len(fileflags) != len(basenames) {
+		return nil, errors.New(fmt.Sprintf("inconsistent file tags in %s", hdr.path))
+	}
+
+	files := make([]packageFile, 0, len(basenames))
+	for i, basename := range basenames {
+		if int(dirindexes[i]) >= len(dirnames) {
+			return nil, errors.New(fmt.Sprintf("invalid dirindex in %s", hdr.path))
+		}
+
+		f := packageFile{name: dirnames[dirindexes[i]] + basename, typ: "file"}
+		switch {
+		case fileflags[i]&rpmfileGhost != 0:
+			f.typ = "ghost"
+		case modes[i]&0170000 == 0040000:
+			f.typ = "dir"
+		}
+		files = append(files, f)
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+// sortFiles sorts files by name, so that a package read from the
+// filelists database, which groups them by directory, has the same files as
+// the one read from the RPM
+func sortFiles(files []packageFile) {
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+}
+
+// readChangelogs reads the changelog of hdr
+func (hdr *rpmheader) readChangelogs() ([]changelog, error) {
+	authors, err := hdr.getStrings("changelogname")
+	if err != nil {
+		return nil, err
+	}
+	dates, err := hdr.getNumbers("changelogtime")
+	if err != nil {
+		return nil, err
+	}
+	texts, err := hdr.getStrings("changelogtext")
+	if err != nil {
+		return nil, err
+	}
+	if len(dates) != len(authors) || len(texts) != len(authors) {
+		return nil, errors.New(fmt.Sprintf("inconsistent changelog tags in %s", hdr.path))
+	}
+
+	changelogs := make([]changelog, len(authors))
+	for i := range authors {
+		changelogs[i] = changelog{authors[i], uint32(dates[i]), texts[i]}
+	}
+	return changelogs, nil
+}
+
+// isPrimaryFile returns true for the files listed in primary as well as in
+// filelists, which are those most often required by path
+func isPrimaryFile(name string) bool {
+	return strings.HasPrefix(name, "/etc/") || strings.Contains(name, "bin/") || name == "/usr/lib/sendmail"
+}
+
+// splitFilelist groups files by directory for the filelist table. It
+// returns the directories in order of appearance, and for each of them the
+// base names joined by "/" and their types as one character each.
+func splitFilelist(files []packageFile) ([]string, map[string]string, map[string]string) {
+	var dirs []string
+	names := make(map[string]string)
+	types := make(map[string]string)
+	for _, f := range files {
+		dir, base := path.Split(f.name)
+		if dir != "/" {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+		if _, ok := names[dir]; !ok {
+			dirs = append(dirs, dir)
+		} else {
+			names[dir] += "/"
+		}
+		names[dir] += base
+		types[dir] += f.typ[:1]
+	}
+	return dirs, names, types
+}
+
+// String returns the dependency like rpm -q --requires prints it
+func (d dependency) String() string {
+	if d.flags == "" {
+		return d.name
+	}
+
+	ops := map[string]string{"EQ": "=", "LT": "<", "LE": "<=", "GT": ">", "GE": ">="}
+	evr := d.version
+	if d.epoch != "" && d.epoch != "0" {
+		evr = d.epoch + ":" + evr
+	}
+	if d.release != "" {
+		evr += "-" + d.release
+	}
+	return d.name + " " + ops[d.flags] + " " + evr
+}
+
+// senseOf returns the sense bits of the flags of d
+func (d dependency) senseOf() int {
+	switch d.flags {
+	case "EQ":
+		return rpmsenseEqual
+	case "LT":
+		return rpmsenseLess
+	case "LE":
+		return rpmsenseLess | rpmsenseEqual
+	case "GT":
+		return rpmsenseGreater
+	case "GE":
+		return rpmsenseGreater | rpmsenseEqual
+	}
+	return 0
+}
+
+// satisfies returns true if the provide d satisfies the requires r of the
+// same name, i.e. their version ranges overlap like rpmdsCompare decides.
+// An unversioned provide or requires always matches, and the releases are
+// only compared if both have one.
+func (d dependency) satisfies(r dependency) bool {
+	provided, required := d.senseOf(), r.senseOf()
+	if provided == 0 || required == 0 {
+		return true
+	}
+
+	sense := compareEpoch(d.epoch, r.epoch)
+	if sense == 0 {
+		sense = Vercmp(d.version, r.version)
+	}
+	if sense == 0 && d.release != "" && r.release != "" {
+		sense = Vercmp(d.release, r.release)
+	}
+
+	switch {
+	case sense < 0:
+		return provided&rpmsenseGreater != 0 || required&rpmsenseLess != 0
+	case sense > 0:
+		return provided&rpmsenseLess != 0 || required&rpmsenseGreater != 0
+	}
+	return (provided&rpmsenseEqual != 0 && required&rpmsenseEqual != 0) ||
+		(provided&rpmsenseLess != 0 && required&rpmsenseLess != 0) ||
+		(provided&rpmsenseGreater != 0 && required&rpmsenseGreater != 0)
+}
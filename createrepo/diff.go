@@ -0,0 +1,284 @@
+package createrepo
+
+import "fmt"
+import "io"
+import "sort"
+import "strings"
+
+// PackageChange is a package in both repositories with another EVR or
+// pkgId
+type PackageChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Dependencies are the added and removed dependencies by kind
+	Dependencies []DependencyChange `json:"dependencies,omitempty"`
+	// Changelog are the entries of the new package newer than those of
+	// the old one, newest first
+	Changelog []ChangelogEntry `json:"changelog,omitempty"`
+}
+
+// DependencyChange lists the dependencies of a kind, e.g. requires, only
+// one of the packages of a PackageChange has
+type DependencyChange struct {
+	Kind    string   `json:"kind"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// DiffReport is the outcome of Diff. Packages are given by NEVRA.
+type DiffReport struct {
+	Added      []string        `json:"added"`
+	Removed    []string        `json:"removed"`
+	Upgraded   []PackageChange `json:"upgraded"`
+	Downgraded []PackageChange `json:"downgraded"`
+	// Rebuilt are the packages with the same NEVRA but another pkgId
+	Rebuilt []PackageChange `json:"rebuilt"`
+}
+
+// Empty returns true if the repositories have the same packages
+func (r *DiffReport) Empty() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Upgraded)+len(r.Downgraded)+len(r.Rebuilt) == 0
+}
+
+// Diff compares the packages of the repository from with those of to.
+// Packages with the same NEVRA are the same unless their pkgId differs. The
+// others are matched by name and arch: the highest EVR of each side is an
+// upgrade or a downgrade, the remaining ones are added or removed.
+func Diff(from *Repo, to *Repo) *DiffReport {
+	report := DiffReport{}
+
+	oldByNEVRA := make(map[NEVRA]*Package)
+	for _, p := range from.packages {
+		oldByNEVRA[p.NEVRA] = p
+	}
+	newByNEVRA := make(map[NEVRA]*Package)
+	for _, p := range to.packages {
+		newByNEVRA[p.NEVRA] = p
+	}
+
+	type nameArch struct{ name, arch string }
+	var keys []nameArch
+	oldRest := make(map[nameArch][]*Package)
+	newRest := make(map[nameArch][]*Package)
+	for _, p := range from.packages {
+		key := nameArch{p.Name, p.Arch}
+		if _, ok := newByNEVRA[p.NEVRA]; !ok {
+			if oldRest[key] == nil && newRest[key] == nil {
+				keys = append(keys, key)
+			}
+			oldRest[key] = append(oldRest[key], p)
+		}
+	}
+	for _, p := range to.packages {
+		key := nameArch{p.Name, p.Arch}
+		same, ok := oldByNEVRA[p.NEVRA]
+		switch {
+		case !ok:
+			if oldRest[key] == nil && newRest[key] == nil {
+				keys = append(keys, key)
+			}
+			newRest[key] = append(newRest[key], p)
+		case same.info.checksum != p.info.checksum:
+			report.Rebuilt = append(report.Rebuilt, comparePackages(same, p))
+		}
+	}
+
+	// the packages of a Repo are sorted by sortByNEVRA, so the last one of
+	// each list is the highest EVR
+	for _, key := range keys {
+		olds, news := oldRest[key], newRest[key]
+		if len(olds) != 0 && len(news) != 0 {
+			o, n := olds[len(olds)-1], news[len(news)-1]
+			if LabelCompare(n.NEVRA, o.NEVRA) > 0 {
+				report.Upgraded = append(report.Upgraded, comparePackages(o, n))
+			} else {
+				report.Downgraded = append(report.Downgraded, comparePackages(o, n))
+			}
+			olds, news = olds[:len(olds)-1], news[:len(news)-1]
+		}
+		for _, p := range olds {
+			report.Removed = append(report.Removed, p.String())
+		}
+		for _, p := range news {
+			report.Added = append(report.Added, p.String())
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	return &report
+}
+
+// comparePackages returns the differences between the packages o and n
+func comparePackages(o *Package, n *Package) PackageChange {
+	c := PackageChange{Old: o.String(), New: n.String()}
+
+	kinds := []struct {
+		name     string
+		old, new []dependency
+	}{
+		{"provides", o.info.provides, n.info.provides},
+		{"requires", o.info.requires, n.info.requires},
+		{"conflicts", o.info.conflicts, n.info.conflicts},
+		{"obsoletes", o.info.obsoletes, n.info.obsoletes},
+	}
+	for _, kind := range kinds {
+		added, removed := diffStrings(dependencyStrings(kind.old), dependencyStrings(kind.new))
+		if len(added) != 0 || len(removed) != 0 {
+			c.Dependencies = append(c.Dependencies, DependencyChange{kind.name, added, removed})
+		}
+	}
+
+	var latest uint32
+	for _, entry := range o.info.changelogs {
+		if entry.date > latest {
+			latest = entry.date
+		}
+	}
+	for _, entry := range n.Changelog() {
+		if entry.Date.Unix() > int64(latest) {
+			c.Changelog = append(c.Changelog, entry)
+		}
+	}
+	return c
+}
+
+func dependencyStrings(deps []dependency) []string {
+	s := make([]string, len(deps))
+	for i, d := range deps {
+		s[i] = d.String()
+	}
+	return s
+}
+
+// diffStrings returns the sorted strings only in b and only in a
+func diffStrings(a []string, b []string) ([]string, []string) {
+	inOld := make(map[string]bool, len(a))
+	for _, s := range a {
+		inOld[s] = true
+	}
+	inNew := make(map[string]bool, len(b))
+	for _, s := range b {
+		inNew[s] = true
+	}
+
+	var added, removed []string
+	for s := range inNew {
+		if !inOld[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range inOld {
+		if !inNew[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// WriteText writes the report to w for humans, one package per line
+func (r *DiffReport) WriteText(w io.Writer) {
+	for _, p := range r.Added {
+		fmt.Fprintf(w, "added %s\n", p)
+	}
+	for _, p := range r.Removed {
+		fmt.Fprintf(w, "removed %s\n", p)
+	}
+
+	changes := []struct {
+		verb    string
+		changes []PackageChange
+	}{
+		{"upgraded", r.Upgraded},
+		{"downgraded", r.Downgraded},
+		{"rebuilt", r.Rebuilt},
+	}
+	for _, kind := range changes {
+		for _, c := range kind.changes {
+			if c.Old == c.New {
+				fmt.Fprintf(w, "%s %s\n", kind.verb, c.New)
+			} else {
+				fmt.Fprintf(w, "%s %s -> %s\n", kind.verb, c.Old, c.New)
+			}
+			for _, d := range c.Dependencies {
+				for _, dep := range d.Added {
+					fmt.Fprintf(w, "  + %s: %s\n", d.Kind, dep)
+				}
+				for _, dep := range d.Removed {
+					fmt.Fprintf(w, "  - %s: %s\n", d.Kind, dep)
+				}
+			}
+			for _, entry := range c.Changelog {
+				fmt.Fprintf(w, "  * %s %s\n", entry.Date.Format("Mon Jan 02 2006"), entry.Author)
+				for _, line := range strings.Split(entry.Text, "\n") {
+					fmt.Fprintf(w, "    %s\n", line)
+				}
+			}
+		}
+	}
+}
+
+// WriteMarkdown writes the report to w as Markdown for release notes
+func (r *DiffReport) WriteMarkdown(w io.Writer) {
+	lists := []struct {
+		title    string
+		packages []string
+	}{
+		{"Added packages", r.Added},
+		{"Removed packages", r.Removed},
+	}
+	for _, list := range lists {
+		if len(list.packages) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "## %s\n\n", list.title)
+		for _, p := range list.packages {
+			fmt.Fprintf(w, "- `%s`\n", p)
+		}
+		fmt.Fprintln(w)
+	}
+
+	changes := []struct {
+		title   string
+		changes []PackageChange
+	}{
+		{"Upgraded packages", r.Upgraded},
+		{"Downgraded packages", r.Downgraded},
+		{"Rebuilt packages", r.Rebuilt},
+	}
+	for _, kind := range changes {
+		if len(kind.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "## %s\n\n", kind.title)
+		for _, c := range kind.changes {
+			if c.Old == c.New {
+				fmt.Fprintf(w, "### `%s`\n\n", c.New)
+			} else {
+				fmt.Fprintf(w, "### `%s` -> `%s`\n\n", c.Old, c.New)
+			}
+			for _, d := range c.Dependencies {
+				for _, dep := range d.Added {
+					fmt.Fprintf(w, "- added %s `%s`\n", d.Kind, dep)
+				}
+				for _, dep := range d.Removed {
+					fmt.Fprintf(w, "- removed %s `%s`\n", d.Kind, dep)
+				}
+			}
+			if len(c.Dependencies) != 0 {
+				fmt.Fprintln(w)
+			}
+			for _, entry := range c.Changelog {
+				fmt.Fprintf(w, "- **%s %s**\n", entry.Date.Format("Mon Jan 02 2006"), entry.Author)
+				for _, line := range strings.Split(entry.Text, "\n") {
+					fmt.Fprintf(w, "  %s\n", line)
+				}
+			}
+			if len(c.Changelog) != 0 {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+}
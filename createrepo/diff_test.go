@@ -0,0 +1,65 @@
+package createrepo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	repo := func(packages ...*packageInfo) *Repo {
+		r := &Repo{}
+		for _, info := range packages {
+			r.packages = append(r.packages, &Package{info.nevra(), info, ""})
+		}
+		sortByNEVRA(r.packages)
+		return r
+	}
+	pkg := func(name string, version string, checksum string, requires ...string) *packageInfo {
+		info := &packageInfo{rpmName: name, rpmVersion: version, rpmRelease: "1", rpmArch: "x86_64", checksum: checksum}
+		for _, r := range requires {
+			info.requires = append(info.requires, dependency{name: r})
+		}
+		return info
+	}
+
+	upgraded := pkg("bash", "5.1", "b2", "glibc", "ncurses")
+	upgraded.changelogs = []changelog{{"dev", 300, "- 5.1"}, {"dev", 200, "- 5.0"}, {"dev", 100, "- 4.4"}}
+	old := pkg("bash", "5.0", "b1", "glibc", "readline")
+	old.changelogs = []changelog{{"dev", 200, "- 5.0"}, {"dev", 100, "- 4.4"}}
+
+	from := repo(old, pkg("zsh", "5.8", "z1"), pkg("curl", "7.2", "c2"), pkg("vim", "8.0", "v1"), pkg("make", "4.3", "m1"))
+	to := repo(upgraded, pkg("zsh", "5.8", "z2"), pkg("curl", "7.1", "c1"), pkg("git", "2.0", "g1"), pkg("make", "4.3", "m1"))
+	report := Diff(from, to)
+
+	shouldEqualStr(t, "added", strings.Join(report.Added, " "), "git-2.0-1.x86_64")
+	shouldEqualStr(t, "removed", strings.Join(report.Removed, " "), "vim-8.0-1.x86_64")
+	if len(report.Upgraded) != 1 || len(report.Downgraded) != 1 || len(report.Rebuilt) != 1 {
+		t.Fatalf("wrong changes %+v", report)
+	}
+	shouldEqualStr(t, "downgraded", report.Downgraded[0].New, "curl-7.1-1.x86_64")
+	shouldEqualStr(t, "rebuilt", report.Rebuilt[0].New, "zsh-5.8-1.x86_64")
+
+	c := report.Upgraded[0]
+	shouldEqualStr(t, "upgraded", c.Old+" "+c.New, "bash-5.0-1.x86_64 bash-5.1-1.x86_64")
+	if len(c.Dependencies) != 1 || c.Dependencies[0].Kind != "requires" {
+		t.Fatalf("wrong dependency changes %+v", c.Dependencies)
+	}
+	shouldEqualStr(t, "added requires", strings.Join(c.Dependencies[0].Added, " "), "ncurses")
+	shouldEqualStr(t, "removed requires", strings.Join(c.Dependencies[0].Removed, " "), "readline")
+	if len(c.Changelog) != 1 || c.Changelog[0].Text != "- 5.1" {
+		t.Errorf("only the new changelog entry expected, got %+v", c.Changelog)
+	}
+
+	var md bytes.Buffer
+	report.WriteMarkdown(&md)
+	for _, s := range []string{"## Added packages\n\n- `git-2.0-1.x86_64`\n", "### `bash-5.0-1.x86_64` -> `bash-5.1-1.x86_64`\n", "- added requires `ncurses`\n", "  - 5.1\n"} {
+		if !strings.Contains(md.String(), s) {
+			t.Errorf("%q missing from the Markdown:\n%s", s, md.String())
+		}
+	}
+
+	if !Diff(from, from).Empty() {
+		t.Error("a repository should not differ from itself")
+	}
+}
@@ -0,0 +1,111 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "log"
+import "sort"
+
+type duplicateMode int
+
+const (
+	// duplicatesWarn reports duplicates but keeps all of them
+	duplicatesWarn duplicateMode = iota
+	// duplicatesError fails the packages whose NEVRA is taken by a file
+	// with a different checksum
+	duplicatesError
+	// duplicatesKeepNewest keeps the file with the newest mtime
+	duplicatesKeepNewest
+	// duplicatesKeepFirst keeps the file whose path sorts first
+	duplicatesKeepFirst
+)
+
+// parseDuplicateMode returns the duplicateMode named by mode, which is one
+// of "warn", "error", "keep-newest" and "keep-first"
+func parseDuplicateMode(mode string) (duplicateMode, error) {
+	switch mode {
+	case "warn":
+		return duplicatesWarn, nil
+	case "error":
+		return duplicatesError, nil
+	case "keep-newest":
+		return duplicatesKeepNewest, nil
+	case "keep-first":
+		return duplicatesKeepFirst, nil
+	}
+	return duplicatesWarn, errors.New(fmt.Sprintf("unknown duplicate policy: %s", mode))
+}
+
+// Duplicate is a NEVRA found in several files
+type Duplicate struct {
+	NEVRA NEVRA
+	// Paths are the files, sorted, or the locations of the packages for
+	// Merge
+	Paths []string
+	// Identical is true if all files have the same checksum, i.e. they are
+	// copies of the same package
+	Identical bool
+	// Kept is the path left in the repodata, it is empty if all of them
+	// were kept or none was
+	Kept string
+}
+
+// findDuplicates groups packages by NEVRA and resolves the groups with more
+// than one file according to mode. Copies with the same checksum are always
+// reduced to the first path except with duplicatesWarn. It returns the kept
+// packages, the duplicates and, for duplicatesError, the failed packages.
+func findDuplicates(packages []*packageInfo, mode duplicateMode) ([]*packageInfo, []Duplicate, []failure) {
+	groups := make(map[NEVRA][]*packageInfo)
+	for _, p := range packages {
+		groups[p.nevra()] = append(groups[p.nevra()], p)
+	}
+
+	var kept []*packageInfo
+	var duplicates []Duplicate
+	var failures []failure
+	for nevra, group := range groups {
+		if len(group) == 1 {
+			kept = append(kept, group[0])
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool { return group[i].path < group[j].path })
+		d := Duplicate{NEVRA: nevra, Identical: true}
+		for _, p := range group {
+			d.Paths = append(d.Paths, p.path)
+			if p.checksum != group[0].checksum || p.checksumType != group[0].checksumType {
+				d.Identical = false
+			}
+		}
+
+		var keep *packageInfo
+		switch {
+		case mode == duplicatesWarn:
+			kept = append(kept, group...)
+		case mode == duplicatesKeepNewest && !d.Identical:
+			keep = group[0]
+			for _, p := range group[1:] {
+				if p.fileTime > keep.fileTime {
+					keep = p
+				}
+			}
+		case mode == duplicatesError && !d.Identical:
+			for _, p := range group {
+				err := errors.New(fmt.Sprintf("%s is also in a file with a different checksum", nevra))
+				failures = append(failures, failure{p.path, err})
+			}
+		default:
+			keep = group[0]
+		}
+		if keep != nil {
+			kept = append(kept, keep)
+			d.Kept = keep.path
+		}
+
+		log.Printf("duplicate %s: %d files, kept %q\n", nevra, len(group), d.Kept)
+		duplicates = append(duplicates, d)
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].path < kept[j].path })
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Paths[0] < duplicates[j].Paths[0] })
+	return kept, duplicates, failures
+}
@@ -0,0 +1,83 @@
+package createrepo
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	pkg := func(path string, checksum string, mtime uint32) *packageInfo {
+		return &packageInfo{
+			path:         path,
+			checksum:     checksum,
+			checksumType: "sha256",
+			fileTime:     mtime,
+			rpmName:      "foo",
+			rpmVersion:   "1.0",
+			rpmRelease:   "1",
+			rpmArch:      "x86_64",
+		}
+	}
+	conflict := []*packageInfo{pkg("/repo/b.rpm", "bbb", 200), pkg("/repo/a.rpm", "aaa", 100), pkg("/repo/c.rpm", "ccc", 300)}
+	copies := []*packageInfo{pkg("/repo/b.rpm", "aaa", 200), pkg("/repo/a.rpm", "aaa", 100)}
+
+	tests := []struct {
+		packages []*packageInfo
+		mode     duplicateMode
+		kept     []string
+		failed   int
+	}{
+		{conflict, duplicatesWarn, []string{"/repo/a.rpm", "/repo/b.rpm", "/repo/c.rpm"}, 0},
+		{conflict, duplicatesKeepNewest, []string{"/repo/c.rpm"}, 0},
+		{conflict, duplicatesKeepFirst, []string{"/repo/a.rpm"}, 0},
+		{conflict, duplicatesError, nil, 3},
+		{copies, duplicatesError, []string{"/repo/a.rpm"}, 0},
+		{copies, duplicatesKeepNewest, []string{"/repo/a.rpm"}, 0},
+	}
+	for i, test := range tests {
+		kept, dups, failures := findDuplicates(test.packages, test.mode)
+		if len(kept) != len(test.kept) {
+			t.Errorf("%d: kept %d packages, expected %v", i, len(kept), test.kept)
+			continue
+		}
+		for j, p := range kept {
+			shouldEqualStr(t, "kept", p.path, test.kept[j])
+		}
+		if len(failures) != test.failed {
+			t.Errorf("%d: %d failures, expected %d", i, len(failures), test.failed)
+		}
+		if len(dups) != 1 || len(dups[0].Paths) != len(test.packages) || dups[0].Paths[0] != "/repo/a.rpm" {
+			t.Errorf("%d: wrong duplicates %+v", i, dups)
+		}
+	}
+
+	if _, dups, _ := findDuplicates(copies[:1], duplicatesError); len(dups) != 0 {
+		t.Error("a single package is no duplicate:", dups)
+	}
+}
+
+func TestGenerateDuplicates(t *testing.T) {
+	dir := newTestRepo(t, "a/openssl.rpm", "b/openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir, WithDuplicatePolicy("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	if result.Indexed != 1 || len(result.Failures) != 0 {
+		t.Errorf("identical copies should be indexed once, got %+v", result)
+	}
+	if len(result.Duplicates) != 1 || !result.Duplicates[0].Identical {
+		t.Errorf("the copies should be reported, got %+v", result.Duplicates)
+	}
+
+	if _, err = NewGenerator(dir, WithDuplicatePolicy("keep-all")); err == nil {
+		t.Error("unknown duplicate policy should be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "sync"
+import "golang.org/x/net/context"
+
+// failure records why a path could not be indexed
+type failure struct {
+	path string
+	err  error
+}
+
+func (f failure) Error() string {
+	return fmt.Sprintf("%s: %s", f.path, f.err.Error())
+}
+
+type failureMode int
+
+const (
+	// failFast aborts the run on the first failure
+	failFast failureMode = iota
+	// skipAndReport skips failed packages and reports them at the end
+	skipAndReport
+	// failureThreshold skips failed packages until there are too many of them
+	failureThreshold
+)
+
+// errorPolicy decides whether the indexing keeps going after a failure
+type errorPolicy struct {
+	mode        failureMode
+	maxFailures int
+}
+
+// parseErrorPolicy returns the errorPolicy named by mode, which is one of
+// "fail-fast", "skip" and "max-failures". maxFailures is only used by the
+// latter.
+func parseErrorPolicy(mode string, maxFailures int) (errorPolicy, error) {
+	switch mode {
+	case "fail-fast":
+		return errorPolicy{mode: failFast}, nil
+	case "skip":
+		return errorPolicy{mode: skipAndReport}, nil
+	case "max-failures":
+		if maxFailures <= 0 {
+			return errorPolicy{}, errors.New(fmt.Sprintf("max-failures must be positive, got %d", maxFailures))
+		}
+		return errorPolicy{mode: failureThreshold, maxFailures: maxFailures}, nil
+	}
+
+	return errorPolicy{}, errors.New(fmt.Sprintf("unknown error policy: %s", mode))
+}
+
+// tolerates returns true if the run may go on after n failures
+func (p errorPolicy) tolerates(n int) bool {
+	switch p.mode {
+	case skipAndReport:
+		return true
+	case failureThreshold:
+		return n <= p.maxFailures
+	}
+	return n == 0
+}
+
+// failureReport is the outcome of collectFailures
+type failureReport struct {
+	failures []failure
+	// aborted is true if the run was canceled because of the policy
+	aborted bool
+}
+
+// mergeFailures fans in the failure channels of the pipeline stages. The
+// returned channel is closed once all of them are closed.
+func mergeFailures(cs ...<-chan failure) <-chan failure {
+	out := make(chan failure)
+
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for _, c := range cs {
+		go func(c <-chan failure) {
+			defer wg.Done()
+			for f := range c {
+				out <- f
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// collectFailures drains errs and calls cancel as soon as the policy no
+// longer tolerates the number of failures. onFailure, if not nil, is called
+// for each failure. The report is sent once errs is closed.
+func collectFailures(policy errorPolicy, cancel context.CancelFunc, errs <-chan failure, onFailure func(failure)) <-chan *failureReport {
+	done := make(chan *failureReport, 1)
+
+	go func() {
+		var report failureReport
+		for f := range errs {
+			if onFailure != nil {
+				onFailure(f)
+			}
+			report.failures = append(report.failures, f)
+			if !report.aborted && !policy.tolerates(len(report.failures)) {
+				report.aborted = true
+				cancel()
+			}
+		}
+		done <- &report
+	}()
+
+	return done
+}
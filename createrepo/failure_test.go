@@ -0,0 +1,70 @@
+package createrepo
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, mode := range []string{"fail-fast", "skip", "max-failures"} {
+		if _, err := parseErrorPolicy(mode, 3); err != nil {
+			t.Error("parseErrorPolicy", mode, "failed:", err.Error())
+		}
+	}
+
+	if _, err := parseErrorPolicy("ignore", 3); err == nil {
+		t.Error("parseErrorPolicy() should reject unknown policies")
+	}
+	if _, err := parseErrorPolicy("max-failures", 0); err == nil {
+		t.Error("parseErrorPolicy() should reject a zero threshold")
+	}
+}
+
+func TestErrorPolicyTolerates(t *testing.T) {
+	failFast, _ := parseErrorPolicy("fail-fast", 0)
+	skip, _ := parseErrorPolicy("skip", 0)
+	threshold, _ := parseErrorPolicy("max-failures", 2)
+
+	cases := []struct {
+		policy   errorPolicy
+		n        int
+		expected bool
+	}{
+		{failFast, 0, true},
+		{failFast, 1, false},
+		{skip, 100, true},
+		{threshold, 2, true},
+		{threshold, 3, false},
+	}
+	for _, c := range cases {
+		if c.policy.tolerates(c.n) != c.expected {
+			t.Errorf("%+v.tolerates(%d) != %v", c.policy, c.n, c.expected)
+		}
+	}
+}
+
+func TestCollectFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan failure)
+	policy, _ := parseErrorPolicy("max-failures", 1)
+	done := collectFailures(policy, cancel, mergeFailures(errs), nil)
+
+	errs <- failure{"a.rpm", errors.New("bad lead magic")}
+	if ctx.Err() != nil {
+		t.Error("the first failure should be tolerated")
+	}
+	errs <- failure{"b.rpm", errors.New("bad header magic")}
+	close(errs)
+
+	report := <-done
+	if !report.aborted || ctx.Err() == nil {
+		t.Error("the second failure should abort the run")
+	}
+	if len(report.failures) != 2 {
+		t.Fatal("expected 2 failures, got", len(report.failures))
+	}
+}
@@ -0,0 +1,617 @@
+// Package createrepo generates the repodata of yum repositories, i.e. the
+// metadata describing a directory of RPM packages.
+//
+//	g, err := createrepo.NewGenerator("/srv/repo", createrepo.WithWorkers(4))
+//	if err != nil {
+//		return err
+//	}
+//	result, err := g.Generate(ctx)
+package createrepo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Generator creates and updates the repodata of a directory of RPMs
+type Generator struct {
+	repo       repository
+	policy     errorPolicy
+	retention  retentionPolicy
+	duplicates duplicateMode
+	dryRun     bool
+	progress   func(Progress)
+
+	// running serializes the runs of Generate and Update
+	running sync.Mutex
+	// mu serializes the progress callback and guards the counters
+	mu      sync.Mutex
+	indexed int
+	reused  int
+	failed  int
+	pruned  []*packageInfo
+	// dups are the duplicates found by dedupe
+	dups []Duplicate
+}
+
+// Option configures a Generator
+type Option func(g *Generator) error
+
+// Progress is passed to the progress callback after each package
+type Progress struct {
+	// Path is the package which was just processed
+	Path string
+	// Err is non-nil if the package could not be indexed
+	Err error
+	// Reused is true if the package was taken over from the previous
+	// repodata by Update
+	Reused bool
+	// Indexed and Failed are the number of packages processed so far
+	Indexed int
+	Failed  int
+}
+
+// Failure is a path which could not be indexed
+type Failure struct {
+	Path string
+	Err  error
+}
+
+// Result summarizes a run of Generate or Update
+type Result struct {
+	// Indexed is the number of packages in the repodata
+	Indexed int
+	// Reused is how many of them were taken over from the previous repodata
+	Reused   int
+	Failures []Failure
+	// Pruned are the paths left out by the retention policy, see
+	// WithRetention
+	Pruned []string
+	// Duplicates are the NEVRAs found in several files, see
+	// WithDuplicatePolicy
+	Duplicates []Duplicate
+	// Aborted is true if the error policy stopped the run
+	Aborted bool
+}
+
+// WriteSummary prints the duplicates and the failed paths with their reason
+// to w
+func (r *Result) WriteSummary(w io.Writer) {
+	writeDuplicates(w, r.Duplicates)
+
+	if len(r.Failures) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%d path(s) failed:\n", len(r.Failures))
+	for _, f := range r.Failures {
+		fmt.Fprintf(w, "  %s: %s\n", f.Path, f.Err.Error())
+	}
+}
+
+// writeDuplicates prints the duplicates with their paths to w
+func writeDuplicates(w io.Writer, duplicates []Duplicate) {
+	if len(duplicates) != 0 {
+		fmt.Fprintf(w, "%d duplicate package(s):\n", len(duplicates))
+	}
+	for _, d := range duplicates {
+		kind := "different checksums"
+		if d.Identical {
+			kind = "identical copies"
+		}
+		fmt.Fprintf(w, "  %s (%s):\n", d.NEVRA, kind)
+		for _, path := range d.Paths {
+			if path == d.Kept {
+				fmt.Fprintf(w, "    %s (kept)\n", path)
+			} else {
+				fmt.Fprintf(w, "    %s\n", path)
+			}
+		}
+	}
+}
+
+// NewGenerator returns a Generator for the RPMs in dir. By default the
+// repodata is written into dir, packages are checksummed with sha256, the
+// metadata is compressed with gzip and failed packages are skipped.
+func NewGenerator(dir string, options ...Option) (*Generator, error) {
+	g := Generator{
+		repo: repository{
+			baseDir:        dir,
+			outputDir:      dir,
+			workers:        runtime.NumCPU(),
+			checksumType:   "sha256",
+			compression:    "gz",
+			followSymlinks: true,
+		},
+		policy: errorPolicy{mode: skipAndReport},
+	}
+
+	for _, option := range options {
+		if err := option(&g); err != nil {
+			return nil, err
+		}
+	}
+	return &g, nil
+}
+
+// WithOutputDir writes repodata/ into dir instead of the package directory
+func WithOutputDir(dir string) Option {
+	return func(g *Generator) error {
+		g.repo.outputDir = dir
+		return nil
+	}
+}
+
+// WithSourceRepo writes the repodata of the source packages into dir
+// instead of the main repodata. Like WithOutputDir, it does not move any
+// package: location_href stays relative to the package directory.
+func WithSourceRepo(dir string) Option {
+	return func(g *Generator) error {
+		g.repo.srpmsDir = dir
+		return nil
+	}
+}
+
+// WithDebugRepo writes the repodata of the debuginfo and debugsource
+// packages into dir instead of the main repodata, like WithSourceRepo.
+func WithDebugRepo(dir string) Option {
+	return func(g *Generator) error {
+		g.repo.debugDir = dir
+		return nil
+	}
+}
+
+// WithArches writes one repository per target architecture instead of the
+// repodata of the output directory. The repository of a target lists the
+// packages of that architecture, the noarch packages and those of its compat
+// architectures, see WithCompatArches. Source packages go into every
+// repository unless WithSourceRepo is used.
+func WithArches(arches ...string) Option {
+	return func(g *Generator) error {
+		for _, arch := range arches {
+			if arch == "" || strings.ContainsAny(arch, "/\\") {
+				return errors.New(fmt.Sprintf("invalid arch %q", arch))
+			}
+		}
+		g.repo.arches = append(g.repo.arches, arches...)
+		return nil
+	}
+}
+
+// WithArchLayout sets the path of the repository of an architecture relative
+// to the output directory, {arch} standing for the architecture. The default
+// is "{arch}", which gives <outputdir>/<arch>/repodata.
+func WithArchLayout(layout string) Option {
+	return func(g *Generator) error {
+		if err := checkArchLayout(layout); err != nil {
+			return err
+		}
+		g.repo.archLayout = layout
+		return nil
+	}
+}
+
+// WithCompatArches sets the multilib architectures included in the
+// repository of target, e.g. i686 for x86_64. It overrides the built-in
+// table for target, an empty list disables multilib.
+func WithCompatArches(target string, compat ...string) Option {
+	return func(g *Generator) error {
+		if g.repo.compatArches == nil {
+			g.repo.compatArches = make(map[string][]string)
+		}
+		g.repo.compatArches[target] = append([]string{}, compat...)
+		return nil
+	}
+}
+
+// WithWorkers sets the number of packages parsed concurrently
+func WithWorkers(n int) Option {
+	return func(g *Generator) error {
+		if n < 1 {
+			return errors.New(fmt.Sprintf("invalid number of workers: %d", n))
+		}
+		g.repo.workers = n
+		return nil
+	}
+}
+
+// WithChecksum sets the checksum type of packages and metadata files: md5,
+// sha1, sha224, sha256, sha384 or sha512
+func WithChecksum(checksumType string) Option {
+	return func(g *Generator) error {
+		if _, err := newHash(checksumType); err != nil {
+			return err
+		}
+		g.repo.checksumType = checksumType
+		return nil
+	}
+}
+
+// WithCompression sets the compression of the metadata files: none, gz, xz
+// or zstd
+func WithCompression(compression string) Option {
+	return func(g *Generator) error {
+		if _, err := getCompressor(compression); err != nil {
+			return err
+		}
+		g.repo.compression = compression
+		return nil
+	}
+}
+
+// WithExcludes skips the paths matching one of the glob patterns. A pattern
+// is matched against the path relative to the package directory as well as
+// against the base name.
+func WithExcludes(patterns ...string) Option {
+	return func(g *Generator) error {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return errors.New(fmt.Sprintf("invalid exclude pattern %q: %s", pattern, err.Error()))
+			}
+		}
+		g.repo.excludes = append(g.repo.excludes, patterns...)
+		return nil
+	}
+}
+
+// WithUploads prepares the Generator for the uploads of a Server: it skips
+// the temporary files of the uploads in progress
+func WithUploads() Option {
+	return WithExcludes(uploadPattern)
+}
+
+// WithPackageList indexes only the RPMs at the given paths instead of every
+// RPM in the package directory. Relative paths are relative to the package
+// directory, and every path must be inside of it. The excludes still apply.
+func WithPackageList(paths ...string) Option {
+	return func(g *Generator) error {
+		g.repo.pkglist = append([]string{}, paths...)
+		return nil
+	}
+}
+
+// WithFollowSymlinks decides whether symlinks to RPMs and directories are
+// followed, which is the default, or skipped.
+func WithFollowSymlinks(follow bool) Option {
+	return func(g *Generator) error {
+		g.repo.followSymlinks = follow
+		return nil
+	}
+}
+
+// ReadPackageList reads a package list for WithPackageList from r. Paths are
+// separated by sep, usually '\n' or 0, and empty entries are ignored.
+func ReadPackageList(r io.Reader, sep byte) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	paths := []string{}
+	for scanner.Scan() {
+		path := scanner.Text()
+		if sep == '\n' {
+			path = strings.TrimRight(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// WithErrorPolicy decides what happens when a package cannot be indexed.
+// mode is one of "fail-fast", "skip" and "max-failures", maxFailures is only
+// used by the latter.
+func WithErrorPolicy(mode string, maxFailures int) Option {
+	return func(g *Generator) error {
+		policy, err := parseErrorPolicy(mode, maxFailures)
+		if err != nil {
+			return err
+		}
+		g.policy = policy
+		return nil
+	}
+}
+
+// WithRetention keeps only the newest builds of every package, identified by
+// name and arch: the keep highest EVRs and the builds younger than maxAge. A
+// zero value disables the respective limit. The highest EVR is always kept.
+// The other builds are left out of the repodata, see WithPrune for what
+// happens to their files.
+func WithRetention(keep int, maxAge time.Duration) Option {
+	return func(g *Generator) error {
+		if keep < 0 || maxAge < 0 {
+			return errors.New(fmt.Sprintf("invalid retention: keep %d, max age %s", keep, maxAge))
+		}
+		g.retention.keep = keep
+		g.retention.maxAge = maxAge
+		return nil
+	}
+}
+
+// WithPrune decides what happens to the files left out by WithRetention once
+// the repodata is written: "none" leaves them alone, "delete" deletes them
+// and "move" moves them into dir, keeping their path relative to the package
+// directory.
+func WithPrune(action string, dir string) Option {
+	return func(g *Generator) error {
+		a, err := parsePruneAction(action)
+		if err != nil {
+			return err
+		}
+		if a == pruneMove && dir == "" {
+			return errors.New("no directory to move the pruned packages into")
+		}
+		g.retention.action = a
+		g.retention.moveDir = dir
+		return nil
+	}
+}
+
+// WithDuplicatePolicy decides what happens to packages with the same NEVRA
+// in several files. With "warn", the default, all of them are indexed and
+// only reported. "keep-newest" keeps the file with the newest mtime and
+// "keep-first" the one whose path sorts first. "error" fails all files of a
+// NEVRA if their checksums differ, so that the error policy applies. Except
+// with "warn", copies with the same checksum are listed once.
+func WithDuplicatePolicy(mode string) Option {
+	return func(g *Generator) error {
+		m, err := parseDuplicateMode(mode)
+		if err != nil {
+			return err
+		}
+		g.duplicates = m
+		return nil
+	}
+}
+
+// WithDryRun indexes the packages without writing the repodata or pruning
+// any file, the Result tells what would have been done.
+func WithDryRun(dryRun bool) Option {
+	return func(g *Generator) error {
+		g.dryRun = dryRun
+		return nil
+	}
+}
+
+// WithProgress calls fn after each package. Calls are serialized.
+func WithProgress(fn func(Progress)) Option {
+	return func(g *Generator) error {
+		g.progress = fn
+		return nil
+	}
+}
+
+// Generate indexes every package and writes the repodata. The error is
+// non-nil if no repodata was written, the Result is returned in any case.
+// Concurrent calls of Generate and Update run one after the other.
+func (g *Generator) Generate(ctx context.Context) (*Result, error) {
+	g.running.Lock()
+	defer g.running.Unlock()
+	return g.run(ctx, nil)
+}
+
+// Update is like Generate but takes over the packages of the existing
+// repodata whose file has not changed instead of parsing them again. It
+// falls back to Generate if there is no usable repodata.
+func (g *Generator) Update(ctx context.Context) (*Result, error) {
+	g.running.Lock()
+	defer g.running.Unlock()
+	return g.update(ctx)
+}
+
+func (g *Generator) update(ctx context.Context) (*Result, error) {
+	cache, err := g.repo.loadCache()
+	if err != nil {
+		log.Printf("no usable repodata in %s, indexing every package: %s\n", g.repo.outputDir, err.Error())
+	}
+	return g.run(ctx, cache)
+}
+
+func (g *Generator) run(ctx context.Context, cache map[string]*packageInfo) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	g.indexed, g.reused, g.failed, g.pruned, g.dups = 0, 0, 0, nil, nil
+
+	files, findErrs := findRPMFiles(ctx, &g.repo)
+	parsed, parseErrs := parseRPMFiles(ctx, &g.repo, files, cache)
+	parsed, dupErrs := g.dedupe(ctx, parsed)
+	report := collectFailures(g.policy, cancel, mergeFailures(findErrs, parseErrs, dupErrs), g.onFailure)
+	if g.retention.enabled() {
+		parsed = g.retain(ctx, parsed)
+	}
+
+	// every package has been staged when genMetadata calls finishStaging,
+	// so all the failures are in: a cancellation by the failure policy
+	// racing with the end of the pipeline must not commit the repodata
+	var failures *failureReport
+	repo := g.repo
+	repo.finishStaging = func(string) error {
+		if failures == nil {
+			failures = <-report
+		}
+		if failures.aborted {
+			return errors.New("aborted: too many failures")
+		}
+		return nil
+	}
+
+	var err error
+	tracked := g.track(ctx, parsed, cache)
+	if g.dryRun {
+		for range tracked {
+		}
+		err = ctx.Err()
+	} else {
+		err = repo.genMetadata(ctx, tracked)
+	}
+	// stop the pipeline if genMetadata bailed out early
+	cancel()
+
+	if failures == nil {
+		failures = <-report
+	}
+	g.mu.Lock()
+	result := Result{Indexed: g.indexed, Reused: g.reused, Aborted: failures.aborted}
+	pruned := g.pruned
+	result.Duplicates = g.dups
+	g.mu.Unlock()
+	for _, f := range failures.failures {
+		result.Failures = append(result.Failures, Failure{f.path, f.err})
+	}
+	if err == nil && failures.aborted {
+		err = errors.New("aborted: too many failures")
+	}
+
+	for _, p := range pruned {
+		result.Pruned = append(result.Pruned, p.path)
+		// the files are only touched once the repodata no longer lists them
+		if err != nil || g.dryRun {
+			continue
+		}
+		if pruneErr := g.retention.prune(p, g.repo.baseDir); pruneErr != nil {
+			result.Failures = append(result.Failures, Failure{p.path, pruneErr})
+		}
+	}
+	return &result, err
+}
+
+// dedupe passes on the packages from in which are kept by the duplicate
+// policy and reports the failed ones to the returned failure channel. Like
+// retain, it has to wait for all packages first.
+func (g *Generator) dedupe(ctx context.Context, in <-chan *packageInfo) (<-chan *packageInfo, <-chan failure) {
+	out := make(chan *packageInfo)
+	errs := make(chan failure)
+
+	go func() {
+		defer close(out)
+		var packages []*packageInfo
+		for p := range in {
+			packages = append(packages, p)
+		}
+
+		kept, dups, failures := findDuplicates(packages, g.duplicates)
+		g.mu.Lock()
+		g.dups = dups
+		g.mu.Unlock()
+
+		// errs is closed before the packages are passed on, so that the
+		// failures count before genMetadata commits the repodata
+		func() {
+			defer close(errs)
+			for _, f := range failures {
+				select {
+				case <-ctx.Done():
+					return
+				case errs <- f:
+				}
+			}
+		}()
+
+		for _, p := range kept {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- p:
+			}
+		}
+	}()
+
+	return out, errs
+}
+
+// retain passes on the packages from in which are kept by the retention
+// policy. As the policy compares the builds of a package, it has to wait
+// for all packages first.
+func (g *Generator) retain(ctx context.Context, in <-chan *packageInfo) <-chan *packageInfo {
+	out := make(chan *packageInfo)
+
+	go func() {
+		defer close(out)
+		var packages []*packageInfo
+		for p := range in {
+			packages = append(packages, p)
+		}
+
+		kept, pruned := g.retention.apply(packages, time.Now())
+		g.mu.Lock()
+		g.pruned = pruned
+		g.mu.Unlock()
+
+		for _, p := range kept {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- p:
+			}
+		}
+	}()
+
+	return out
+}
+
+// track counts the packages going from in to the returned channel and
+// reports them to the progress callback. Packages which do not belong into
+// any repository, e.g. of an architecture not in WithArches, are dropped.
+func (g *Generator) track(ctx context.Context, in <-chan *packageInfo, cache map[string]*packageInfo) <-chan *packageInfo {
+	out := make(chan *packageInfo)
+
+	go func() {
+		defer close(out)
+		for p := range in {
+			if len(g.repo.route(p)) == 0 {
+				log.Printf("skipped %s: arch %s is not in any repository\n", p.path, p.rpmArch)
+				continue
+			}
+			reused := cache != nil && cache[p.path] == p
+
+			g.mu.Lock()
+			g.indexed++
+			if reused {
+				g.reused++
+			}
+			if g.progress != nil {
+				g.progress(Progress{Path: p.path, Reused: reused, Indexed: g.indexed, Failed: g.failed})
+			}
+			g.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- p:
+			}
+		}
+	}()
+
+	return out
+}
+
+func (g *Generator) onFailure(f failure) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failed++
+	if g.progress != nil {
+		g.progress(Progress{Path: f.path, Err: f.err, Indexed: g.indexed, Failed: g.failed})
+	}
+}
@@ -0,0 +1,320 @@
+package createrepo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// newTestRepo returns a temporary directory holding copies of openssl.rpm
+// under the given names.
+func newTestRepo(t *testing.T, names ...string) string {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNewGeneratorOptions(t *testing.T) {
+	invalid := []Option{
+		WithWorkers(0),
+		WithChecksum("crc32"),
+		WithCompression("bz2"),
+		WithExcludes("[a-"),
+		WithErrorPolicy("ignore", 0),
+	}
+	for _, option := range invalid {
+		if _, err := NewGenerator(".", option); err == nil {
+			t.Error("NewGenerator() should reject an invalid option")
+		}
+	}
+}
+
+func TestGenerateAndUpdate(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm", "sub/openssl-copy.rpm")
+	defer os.RemoveAll(dir)
+
+	var progress []Progress
+	g, err := NewGenerator(dir, WithWorkers(2), WithProgress(func(p Progress) {
+		progress = append(progress, p)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	if result.Indexed != 2 || result.Reused != 0 || len(progress) != 2 {
+		t.Errorf("unexpected result: %+v, %d progress calls", result, len(progress))
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "broken.rpm"), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = g.Update(context.Background())
+	if err != nil {
+		t.Fatal("Update() failed:", err.Error())
+	}
+	if result.Indexed != 2 || result.Reused != 2 || len(result.Failures) != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	var summary bytes.Buffer
+	result.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "broken.rpm") {
+		t.Errorf("summary %q does not mention broken.rpm", summary.String())
+	}
+}
+
+func TestGenerateFailFast(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.rpm"), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(dir, WithErrorPolicy("fail-fast", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.Generate(context.Background())
+	if err == nil || !result.Aborted {
+		t.Error("Generate() should abort on the first failure")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "repodata")); !os.IsNotExist(err) {
+		t.Error("no repodata should be written when aborted")
+	}
+}
+
+func TestReadPackageList(t *testing.T) {
+	paths, err := ReadPackageList(strings.NewReader("a.rpm\r\n\nsub/b.rpm\n"), '\n')
+	if err != nil || len(paths) != 2 || paths[0] != "a.rpm" || paths[1] != "sub/b.rpm" {
+		t.Errorf("ReadPackageList(newline) = %v, %v", paths, err)
+	}
+
+	paths, err = ReadPackageList(strings.NewReader("a b.rpm\x00c\nd.rpm\x00"), 0)
+	if err != nil || len(paths) != 2 || paths[0] != "a b.rpm" || paths[1] != "c\nd.rpm" {
+		t.Errorf("ReadPackageList(NUL) = %q, %v", paths, err)
+	}
+}
+
+func TestGeneratePackageList(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm", "sub/openssl-copy.rpm", "other/openssl-old.rpm")
+	defer os.RemoveAll(dir)
+
+	pkglist := []string{"sub/openssl-copy.rpm", filepath.Join(dir, "openssl.rpm"), "../outside.rpm"}
+	g, err := NewGenerator(dir, WithPackageList(pkglist...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	if result.Indexed != 2 || len(result.Failures) != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestGenerateDetectsPackagesByLead(t *testing.T) {
+	dir := newTestRepo(t, "OPENSSL.RPM", "openssl.package")
+	defer os.RemoveAll(dir)
+
+	junk := map[string]string{
+		"junk.rpm":   "not a package",
+		"README":     "not a package either",
+		"delta.drpm": "drpm\x00\x00\x00\x01",
+	}
+	for name, content := range junk {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDeltaRPM(t, filepath.Join(dir, "standard.drpm"), "gz")
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	if result.Indexed != 2 || len(result.Failures) != 1 || filepath.Base(result.Failures[0].Path) != "junk.rpm" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+// writeSourceRPM turns the copy of openssl.rpm at path into a source RPM by
+// setting the lead type and renaming the sourcerpm tag (1044) of the header
+// to the unused 1043, which keeps the index sorted.
+func writeSourceRPM(t *testing.T, path string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := readHeaderBlob(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content[7] = 1
+	index := content[blob.start+headerIntroSize : blob.end]
+	for i := 0; i+16 <= len(index); i += 16 {
+		if index[i] == 0 && index[i+1] == 0 && index[i+2] == 0x04 && index[i+3] == 0x14 {
+			index[i+3] = 0x13
+			break
+		}
+	}
+
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateSplitsSourceRepo(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm", "openssl.src.rpm")
+	defer os.RemoveAll(dir)
+	writeSourceRPM(t, filepath.Join(dir, "openssl.src.rpm"))
+
+	srpmsDir := filepath.Join(dir, "SRPMS")
+	debugDir := filepath.Join(dir, "debug")
+	g, err := NewGenerator(dir, WithSourceRepo(srpmsDir), WithDebugRepo(debugDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	expected := map[string]string{dir: "x86_64", srpmsDir: "src", debugDir: ""}
+	for repoDir, arch := range expected {
+		packages, err := loadPackages(repoDir, dir)
+		if err != nil {
+			t.Fatal("loadPackages", repoDir, "failed:", err.Error())
+		}
+		switch {
+		case arch == "" && len(packages) != 0:
+			t.Errorf("%s should be empty, got %d packages", repoDir, len(packages))
+		case arch == "":
+		case len(packages) != 1:
+			t.Errorf("%s should have 1 package, got %d", repoDir, len(packages))
+		case packages[0].rpmArch != arch:
+			t.Errorf("%s has a package of arch %s, expected %s", repoDir, packages[0].rpmArch, arch)
+		case arch == "src" && packages[0].rpmSourceRpm != nil:
+			t.Error("rpm_sourcerpm of a source package should be NULL")
+		}
+	}
+}
+
+func TestGenerateArches(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	g, err := NewGenerator(dir, WithOutputDir(out), WithArches("x86_64", "aarch64"), WithArchLayout("{arch}/os"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	for arch, n := range map[string]int{"x86_64": 1, "aarch64": 0} {
+		packages, err := loadPackages(filepath.Join(out, arch, "os"), dir)
+		if err != nil {
+			t.Fatal("loadPackages", arch, "failed:", err.Error())
+		}
+		if len(packages) != n {
+			t.Errorf("repository of %s should have %d package(s), got %d", arch, n, len(packages))
+		}
+	}
+	if _, err = os.Stat(filepath.Join(out, "repodata")); !os.IsNotExist(err) {
+		t.Error("no repodata should be written into the output directory itself")
+	}
+
+	if _, err = NewGenerator(dir, WithArchLayout("repos")); err == nil {
+		t.Error("a layout without {arch} should be rejected")
+	}
+}
+
+func TestGenerateReproducible(t *testing.T) {
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	mtime := time.Unix(1600000000, 0)
+	var repomds, primaries [][]byte
+	for i := 0; i < 2; i++ {
+		dir := newTestRepo(t, "c.rpm", "a/b.rpm", "b.rpm", "a.rpm")
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"c.rpm", "a/b.rpm", "b.rpm", "a.rpm"} {
+			os.Chtimes(filepath.Join(dir, name), mtime, mtime)
+		}
+
+		g, err := NewGenerator(dir, WithWorkers(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = g.Generate(context.Background()); err != nil {
+			t.Fatal("Generate() failed:", err.Error())
+		}
+
+		repomd, err := ioutil.ReadFile(filepath.Join(dir, "repodata", "repomd.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		primary, err := ioutil.ReadFile(filepath.Join(dir, "repodata", "primary.sqlite.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repomds = append(repomds, repomd)
+		primaries = append(primaries, primary)
+
+		packages, err := loadPackages(dir, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, name := range []string{"a.rpm", "a/b.rpm", "b.rpm", "c.rpm"} {
+			shouldEqualStr(t, "package order", packages[j].path, filepath.Join(dir, name))
+		}
+	}
+
+	if !bytes.Equal(repomds[0], repomds[1]) {
+		t.Errorf("repomd.xml differs:\n%s\n%s", repomds[0], repomds[1])
+	}
+	if !bytes.Contains(repomds[0], []byte("<revision>1700000000</revision>")) {
+		t.Error("revision should be SOURCE_DATE_EPOCH:", string(repomds[0]))
+	}
+	if !bytes.Equal(primaries[0], primaries[1]) {
+		t.Error("primary.sqlite.gz differs")
+	}
+}
@@ -0,0 +1,290 @@
+package createrepo
+
+import "bufio"
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+
+const (
+	// leadSize is the size of the (obsolete but still present) RPM lead
+	leadSize = 96
+	// headerIntroSize is the size of magic, version, reserved bytes and the
+	// index/data counts in front of a header structure
+	headerIntroSize = 16
+	// maxHeaderIndex and maxHeaderData are the sanity limits librpm uses
+	maxHeaderIndex = 0x0000ffff
+	maxHeaderData  = 0x0fffffff
+)
+
+var leadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+var headerMagic = []byte{0x8e, 0xad, 0xe8}
+
+// deltaMagic starts the rpm-only delta RPMs created by makedeltarpm -r
+var deltaMagic = []byte("drpm")
+
+// deltaDataMagic starts the delta data of the other delta RPMs, which have
+// the lead, signature and header of the new package and the delta data,
+// compressed, instead of its payload: DLT1, DLT2 or DLT3
+var deltaDataMagic = []byte("DLT")
+
+// packageKind is what a file claims to be according to its first bytes
+type packageKind int
+
+const (
+	notPackage packageKind = iota
+	binaryPackage
+	sourcePackage
+	deltaPackage
+)
+
+func (k packageKind) String() string {
+	switch k {
+	case binaryPackage:
+		return "binary RPM"
+	case sourcePackage:
+		return "source RPM"
+	case deltaPackage:
+		return "delta RPM"
+	}
+	return "not a RPM"
+}
+
+// classifyLead returns the kind of package whose first bytes are lead. For
+// notPackage the error tells why.
+func classifyLead(lead []byte) (packageKind, error) {
+	if len(lead) >= len(deltaMagic) && bytes.Equal(lead[:len(deltaMagic)], deltaMagic) {
+		return deltaPackage, nil
+	}
+	if len(lead) < leadSize {
+		return notPackage, errors.New(fmt.Sprintf("too short for a RPM lead: %d bytes", len(lead)))
+	}
+	if !bytes.Equal(lead[:len(leadMagic)], leadMagic) {
+		return notPackage, errors.New(fmt.Sprintf("bad lead magic: % x", lead[:len(leadMagic)]))
+	}
+
+	// the lead type follows the magic and the major/minor version
+	switch leadType := binary.BigEndian.Uint16(lead[6:8]); leadType {
+	case 0:
+		return binaryPackage, nil
+	case 1:
+		return sourcePackage, nil
+	default:
+		return notPackage, errors.New(fmt.Sprintf("unknown lead type: %d", leadType))
+	}
+}
+
+// detectPackage reads the lead of the file at path and classifies it. The
+// payload of binary packages is checked for delta data as well.
+func detectPackage(path string) (packageKind, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return notPackage, err
+	}
+	defer file.Close()
+
+	lead := make([]byte, leadSize)
+	n, err := io.ReadFull(file, lead)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return notPackage, err
+	}
+	kind, err := classifyLead(lead[:n])
+	if kind == binaryPackage && seekPayload(file) == nil && isDeltaData(file) {
+		kind = deltaPackage
+	}
+	return kind, err
+}
+
+// seekPayload moves f, positioned after the lead, past the signature and
+// the header without reading them
+func seekPayload(f io.ReadSeeker) error {
+	intro := make([]byte, headerIntroSize)
+	sigIndex, sigData, err := readHeaderIntro(f, intro)
+	if err != nil {
+		return err
+	}
+	// the signature is padded to the next 8 byte boundary
+	sigSize := int64(sigIndex)*16 + int64(sigData)
+	if _, err = f.Seek((sigSize+7)/8*8, io.SeekCurrent); err != nil {
+		return err
+	}
+
+	hdrIndex, hdrData, err := readHeaderIntro(f, intro)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(int64(hdrIndex)*16+int64(hdrData), io.SeekCurrent)
+	return err
+}
+
+// isDeltaData returns true if the payload read from r, compressed in any
+// format of compressors or not at all, starts with delta data
+func isDeltaData(r io.Reader) bool {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(8)
+	payload := ioutil.NopCloser(br)
+	for _, c := range compressors {
+		if c.magic == nil || !bytes.HasPrefix(start, c.magic) {
+			continue
+		}
+		var err error
+		if payload, err = c.newReader(br); err != nil {
+			return false
+		}
+		break
+	}
+	defer payload.Close()
+
+	magic := make([]byte, len(deltaDataMagic)+1)
+	if _, err := io.ReadFull(payload, magic); err != nil {
+		return false
+	}
+	version := magic[len(deltaDataMagic)]
+	return bytes.Equal(magic[:len(deltaDataMagic)], deltaDataMagic) && version >= '1' && version <= '3'
+}
+
+// headerBlob is the main header of a RPM as it is stored on disk.
+type headerBlob struct {
+	// data is the header without the leading magic and reserved bytes,
+	// i.e. the format headerImport() expects.
+	data []byte
+	// signature is the signature header in the same format as data. Some
+	// tags, e.g. the archive size of older packages, are only found there.
+	signature []byte
+	// start and end are the byte range of the header in the RPM file
+	start uint64
+	end   uint64
+}
+
+// readHeaderIntro reads the 16 bytes in front of a header structure and
+// returns the size of its index and data store.
+func readHeaderIntro(r io.Reader, intro []byte) (uint32, uint32, error) {
+	if _, err := io.ReadFull(r, intro); err != nil {
+		return 0, 0, err
+	}
+
+	if intro[0] != headerMagic[0] || intro[1] != headerMagic[1] || intro[2] != headerMagic[2] {
+		return 0, 0, errors.New("bad header magic")
+	}
+
+	index := binary.BigEndian.Uint32(intro[8:12])
+	data := binary.BigEndian.Uint32(intro[12:16])
+	if index > maxHeaderIndex || data > maxHeaderData {
+		return 0, 0, errors.New(fmt.Sprintf("header too large: %d entries, %d bytes of data", index, data))
+	}
+
+	return index, data, nil
+}
+
+// readHeaderBlob reads the lead, the signature and the main header of a RPM
+// from r. r is left positioned right after the main header, so the caller can
+// keep consuming the payload from it, e.g. to checksum the whole file while
+// reading it only once.
+func readHeaderBlob(r io.Reader) (*headerBlob, error) {
+	lead := make([]byte, leadSize)
+	n, err := io.ReadFull(r, lead)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	switch kind, err := classifyLead(lead[:n]); {
+	case err != nil:
+		return nil, err
+	case kind == deltaPackage:
+		return nil, errors.New("delta RPMs have no header to index")
+	}
+
+	intro := make([]byte, headerIntroSize)
+	sigIndex, sigData, err := readHeaderIntro(r, intro)
+	if err != nil {
+		return nil, err
+	}
+
+	var blob headerBlob
+	sigSize := uint64(sigIndex)*16 + uint64(sigData)
+	blob.signature = make([]byte, 8+sigSize)
+	copy(blob.signature, intro[8:])
+	if _, err = io.ReadFull(r, blob.signature[8:]); err != nil {
+		return nil, err
+	}
+
+	// the signature is padded to the next 8 byte boundary
+	if distToBoundary := sigSize % 8; distToBoundary != 0 {
+		if _, err = io.CopyN(ioutil.Discard, r, int64(8-distToBoundary)); err != nil {
+			return nil, err
+		}
+		sigSize += 8 - distToBoundary
+	}
+
+	blob.start = leadSize + headerIntroSize + sigSize
+	hdrIndex, hdrData, err := readHeaderIntro(r, intro)
+	if err != nil {
+		return nil, err
+	}
+
+	hdrSize := uint64(hdrIndex)*16 + uint64(hdrData)
+	blob.data = make([]byte, 8+hdrSize)
+	copy(blob.data, intro[8:])
+	if _, err = io.ReadFull(r, blob.data[8:]); err != nil {
+		return nil, err
+	}
+	blob.end = blob.start + headerIntroSize + hdrSize
+
+	return &blob, nil
+}
+
+/* For how to get header start/end
+   def _get_header_byte_range(self):
+       """takes an rpm file or fileobject and returns byteranges for location of the header"""
+       if self._hdrstart and self._hdrend:
+           return (self._hdrstart, self._hdrend)
+
+
+       fo = open(self.localpath, 'r')
+       #read in past lead and first 8 bytes of sig header
+       fo.seek(104)
+       # 104 bytes in
+       binindex = fo.read(4)
+       # 108 bytes in
+       (sigindex, ) = struct.unpack('>I', binindex)
+       bindata = fo.read(4)
+       # 112 bytes in
+       (sigdata, ) = struct.unpack('>I', bindata)
+       # each index is 4 32bit segments - so each is 16 bytes
+       sigindexsize = sigindex * 16
+       sigsize = sigdata + sigindexsize
+       # we have to round off to the next 8 byte boundary
+       disttoboundary = (sigsize % 8)
+       if disttoboundary != 0:
+           disttoboundary = 8 - disttoboundary
+       # 112 bytes - 96 == lead, 8 = magic and reserved, 8 == sig header data
+       hdrstart = 112 + sigsize  + disttoboundary
+
+       fo.seek(hdrstart) # go to the start of the header
+       fo.seek(8,1) # read past the magic number and reserved bytes
+
+       binindex = fo.read(4)
+       (hdrindex, ) = struct.unpack('>I', binindex)
+       bindata = fo.read(4)
+       (hdrdata, ) = struct.unpack('>I', bindata)
+
+       # each index is 4 32bit segments - so each is 16 bytes
+       hdrindexsize = hdrindex * 16
+       # add 16 to the hdrsize to account for the 16 bytes of misc data b/t the
+       # end of the sig and the header.
+       hdrsize = hdrdata + hdrindexsize + 16
+
+       # header end is hdrstart + hdrsize
+       hdrend = hdrstart + hdrsize
+       fo.close()
+       self._hdrstart = hdrstart
+       self._hdrend = hdrend
+
+       return (hdrstart, hdrend)
+
+   hdrend = property(fget=lambda self: self._get_header_byte_range()[1])
+   hdrstart = property(fget=lambda self: self._get_header_byte_range()[0])
+
+*/
@@ -0,0 +1,124 @@
+package createrepo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadHeaderBlob(t *testing.T) {
+	file, err := os.Open("openssl.rpm")
+	if err != nil {
+		t.Fatal("open(openssl.rpm) failed:", err.Error())
+	}
+	defer file.Close()
+
+	blob, err := readHeaderBlob(file)
+	if err != nil {
+		t.Fatal("readHeaderBlob(openssl.rpm) failed:", err.Error())
+	}
+
+	shouldEqualU64(t, "blob.start", blob.start, 1384)
+	shouldEqualU64(t, "blob.end", blob.end, 61140)
+	shouldEqualU64(t, "len(blob.data)", uint64(len(blob.data)), 61140-1384-8)
+
+	offset, err := file.Seek(0, 1)
+	if err != nil {
+		t.Fatal("seek failed:", err.Error())
+	}
+	shouldEqualU64(t, "file offset after header", uint64(offset), 61140)
+}
+
+func TestReadHeaderBlobBadMagic(t *testing.T) {
+	_, err := readHeaderBlob(bytes.NewReader(make([]byte, 1024)))
+	if err == nil {
+		t.Error("readHeaderBlob() should reject a file without RPM lead")
+	}
+}
+
+func TestClassifyLead(t *testing.T) {
+	file, err := os.Open("openssl.rpm")
+	if err != nil {
+		t.Fatal("open(openssl.rpm) failed:", err.Error())
+	}
+	defer file.Close()
+
+	lead := make([]byte, leadSize)
+	if _, err = file.Read(lead); err != nil {
+		t.Fatal(err)
+	}
+
+	source := append([]byte{}, lead...)
+	source[7] = 1
+	unknown := append([]byte{}, lead...)
+	unknown[7] = 7
+
+	cases := []struct {
+		lead     []byte
+		expected packageKind
+	}{
+		{lead, binaryPackage},
+		{source, sourcePackage},
+		{[]byte("drpm\x00\x00\x00\x01"), deltaPackage},
+		{unknown, notPackage},
+		{lead[:50], notPackage},
+		{[]byte("<?xml version=\"1.0\"?>\n" + string(make([]byte, leadSize))), notPackage},
+	}
+	for i, c := range cases {
+		kind, err := classifyLead(c.lead)
+		if kind != c.expected {
+			t.Errorf("case %d: classifyLead() = %s, expected %s", i, kind, c.expected)
+		}
+		if (kind == notPackage) != (err != nil) {
+			t.Errorf("case %d: classifyLead() returned %s with error %v", i, kind, err)
+		}
+	}
+}
+
+// writeDeltaRPM writes a standard delta RPM to path: the lead, signature and
+// header of openssl.rpm followed by delta data compressed with compression
+func writeDeltaRPM(t *testing.T, path string, compression string) {
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := readHeaderBlob(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delta := bytes.NewBuffer(append([]byte{}, content[:blob.end]...))
+	data := []byte("DLT3\x00\x00\x00\x10openssl-1.0.1e")
+	if compression == "gz" {
+		w := gzip.NewWriter(delta)
+		w.Write(data)
+		w.Close()
+	} else {
+		delta.Write(data)
+	}
+	if err = ioutil.WriteFile(path, delta.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectPackageDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, compression := range []string{"gz", "none"} {
+		path := filepath.Join(dir, compression+".drpm")
+		writeDeltaRPM(t, path, compression)
+		if kind, err := detectPackage(path); kind != deltaPackage || err != nil {
+			t.Errorf("%s: detectPackage() = %s, %v, expected a delta RPM", compression, kind, err)
+		}
+	}
+	if kind, err := detectPackage("openssl.rpm"); kind != binaryPackage || err != nil {
+		t.Errorf("detectPackage(openssl.rpm) = %s, %v", kind, err)
+	}
+}
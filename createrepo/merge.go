@@ -0,0 +1,475 @@
+package createrepo
+
+import "bytes"
+import "encoding/xml"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "path/filepath"
+import "regexp"
+import "strings"
+import "golang.org/x/net/context"
+
+type mergePolicy int
+
+const (
+	// mergeFirst keeps the package of the first repository with a NEVRA
+	mergeFirst mergePolicy = iota
+	// mergeNewest keeps the package with the newest build time
+	mergeNewest
+	// mergeAll keeps the packages of all repositories
+	mergeAll
+)
+
+// parseMergePolicy returns the mergePolicy named by policy, which is one of
+// "first", the default, "newest" and "all"
+func parseMergePolicy(policy string) (mergePolicy, error) {
+	switch policy {
+	case "", "first":
+		return mergeFirst, nil
+	case "newest":
+		return mergeNewest, nil
+	case "all":
+		return mergeAll, nil
+	}
+	return mergeFirst, errors.New(fmt.Sprintf("unknown merge policy: %s", policy))
+}
+
+// MergeSource is a repository merged by Merge
+type MergeSource struct {
+	// Dir is the directory containing repodata/
+	Dir string
+	// BaseURL is the location_base of its packages, the file:// URL of Dir
+	// if empty
+	BaseURL string
+}
+
+func (src MergeSource) baseURL() (string, error) {
+	if src.BaseURL != "" {
+		return src.BaseURL, nil
+	}
+	dir, err := filepath.Abs(src.Dir)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(dir), nil
+}
+
+// MergeOptions configure Merge
+type MergeOptions struct {
+	// Policy decides which packages of a NEVRA found in several
+	// repositories are kept: "first" (the default), "newest" or "all"
+	Policy string
+	// ChecksumType is the checksum of the metadata, sha256 by default
+	ChecksumType string
+	// Compression is the compression of the metadata, gz by default
+	Compression string
+}
+
+// MergeResult is the outcome of Merge
+type MergeResult struct {
+	// Packages is the number of packages in the merged repodata
+	Packages int
+	// Duplicates are the NEVRAs found in several repositories, with the
+	// locations of the packages in the order of the repositories
+	Duplicates []Duplicate
+	// Metadata are the types of the extra metadata merged, e.g. group
+	Metadata []string
+}
+
+// WriteSummary prints the duplicates to w
+func (r *MergeResult) WriteSummary(w io.Writer) {
+	writeDuplicates(w, r.Duplicates)
+}
+
+// Merge writes into outputDir/repodata the packages of the repodata of all
+// sources, without reading any RPM. The packages keep their location in
+// their repository, with its URL as location_base. The extra metadata such
+// as groups is merged as well: the children of the root element of XML
+// files are merged by their <id>, the first one winning, and YAML streams
+// are concatenated.
+func Merge(ctx context.Context, outputDir string, sources []MergeSource, options MergeOptions) (*MergeResult, error) {
+	if options.ChecksumType == "" {
+		options.ChecksumType = "sha256"
+	}
+	if _, err := newHash(options.ChecksumType); err != nil {
+		return nil, err
+	}
+	if options.Compression == "" {
+		options.Compression = "gz"
+	}
+	if _, err := getCompressor(options.Compression); err != nil {
+		return nil, err
+	}
+	policy, err := parseMergePolicy(options.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no repository to merge")
+	}
+
+	var packages []*packageInfo
+	extras := make(map[string][]extraMetadata)
+	var mdTypes []string
+	for _, src := range sources {
+		if ctx.Err() != nil {
+			return nil, errors.New(fmt.Sprintf("merging canceled: %s", ctx.Err().Error()))
+		}
+
+		infos, err := loadPackages(src.Dir, src.Dir)
+		if err != nil {
+			return nil, err
+		}
+		base, err := src.baseURL()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range infos {
+			// packages of a merged repository are already elsewhere
+			if p.locationBase == nil {
+				p.locationBase = &base
+			}
+		}
+		packages = append(packages, infos...)
+
+		files, err := readExtraMetadata(src.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if _, ok := extras[f.mdType]; !ok {
+				mdTypes = append(mdTypes, f.mdType)
+			}
+			extras[f.mdType] = append(extras[f.mdType], f)
+		}
+	}
+
+	result := MergeResult{}
+	packages, result.Duplicates = mergePackages(packages, policy)
+	result.Packages = len(packages)
+
+	repo := repository{
+		baseDir:      outputDir,
+		outputDir:    outputDir,
+		checksumType: options.ChecksumType,
+		compression:  options.Compression,
+	}
+	// the extra metadata goes into the staging repodata, so that it is
+	// part of the repodata as soon as that replaces the previous one
+	repo.finishStaging = func(stagingDir string) error {
+		if len(mdTypes) == 0 {
+			return nil
+		}
+		md, err := readRepomd(stagingDir)
+		if err != nil {
+			return err
+		}
+		for _, mdType := range mdTypes {
+			files := extras[mdType]
+			content, err := mergeMetadata(files)
+			if err != nil {
+				log.Printf("only keeping the %s of %s: %s\n", mdType, files[0].repoDir, err.Error())
+				content = files[0].content
+			}
+
+			path := filepath.Join(stagingDir, files[0].name)
+			if err = ioutil.WriteFile(path, content, 0644); err != nil {
+				return err
+			}
+			data, err := addMetadataFile(path, mdType, options.ChecksumType, options.Compression)
+			if err != nil {
+				return err
+			}
+			md.set(data)
+			result.Metadata = append(result.Metadata, mdType)
+		}
+		return md.write(stagingDir)
+	}
+	c := make(chan *packageInfo)
+	go func() {
+		defer close(c)
+		for _, p := range packages {
+			c <- p
+		}
+	}()
+	if err = repo.genMetadata(ctx, c); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// location returns the URL of p, its location_href relative to its
+// location_base if it has one
+func (p *packageInfo) location() string {
+	if p.locationBase == nil {
+		return p.locationHref
+	}
+	return strings.TrimSuffix(*p.locationBase, "/") + "/" + p.locationHref
+}
+
+// mergePackages resolves the NEVRAs found in several repositories according
+// to policy. packages are in the order of the repositories.
+func mergePackages(packages []*packageInfo, policy mergePolicy) ([]*packageInfo, []Duplicate) {
+	groups := make(map[NEVRA][]*packageInfo)
+	var order []NEVRA
+	for _, p := range packages {
+		nevra := p.nevra()
+		if _, ok := groups[nevra]; !ok {
+			order = append(order, nevra)
+		}
+		groups[nevra] = append(groups[nevra], p)
+	}
+
+	var kept []*packageInfo
+	var duplicates []Duplicate
+	for _, nevra := range order {
+		group := groups[nevra]
+		if len(group) == 1 {
+			kept = append(kept, group[0])
+			continue
+		}
+
+		d := Duplicate{NEVRA: nevra, Identical: true}
+		for _, p := range group {
+			d.Paths = append(d.Paths, p.location())
+			if p.checksum != group[0].checksum || p.checksumType != group[0].checksumType {
+				d.Identical = false
+			}
+		}
+
+		var keep *packageInfo
+		switch policy {
+		case mergeAll:
+			kept = append(kept, group...)
+		case mergeNewest:
+			keep = group[0]
+			for _, p := range group[1:] {
+				if p.rpmBuildTime > keep.rpmBuildTime {
+					keep = p
+				}
+			}
+		default:
+			keep = group[0]
+		}
+		if keep != nil {
+			kept = append(kept, keep)
+			d.Kept = keep.location()
+		}
+
+		log.Printf("duplicate %s: %d packages, kept %q\n", nevra, len(group), d.Kept)
+		duplicates = append(duplicates, d)
+	}
+	return kept, duplicates
+}
+
+// extraMetadata is a metadata file of a repository other than the package
+// databases, e.g. the groups
+type extraMetadata struct {
+	repoDir string
+	mdType  string
+	// name is the file name without checksum prefix and compression suffix
+	name    string
+	content []byte
+}
+
+// packageMetadataTypes are the metadata types describing the packages,
+// which Merge rewrites instead of merging them
+var packageMetadataTypes = map[string]bool{
+	"primary": true, "filelists": true, "filelists_ext": true, "other": true,
+}
+
+// derivedTypeSuffixes are the suffixes of the types of other forms of a
+// metadata, like group_gz for group or primary_db for primary
+var derivedTypeSuffixes = []string{"_db", "_gz", "_xz", "_zck", "_zst"}
+
+var checksumPrefix = regexp.MustCompile(`^[0-9a-f]{32,128}-`)
+
+// readExtraMetadata reads the extra metadata of the repository in repoDir,
+// decompressed. Other forms of an entry, like group_gz, are skipped.
+func readExtraMetadata(repoDir string) ([]extraMetadata, error) {
+	md, err := readRepomd(filepath.Join(repoDir, "repodata"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []extraMetadata
+	for _, data := range md.Data {
+		baseType, copied := data.Type, false
+		for _, suffix := range derivedTypeSuffixes {
+			if strings.HasSuffix(data.Type, suffix) {
+				baseType = strings.TrimSuffix(data.Type, suffix)
+				copied = md.find(baseType) != nil
+			}
+		}
+		if packageMetadataTypes[baseType] || copied {
+			continue
+		}
+
+		path := filepath.Join(repoDir, filepath.FromSlash(data.Location.Href))
+		in, err := openDecompressed(path)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(in)
+		in.Close()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
+		}
+
+		name := filepath.Base(path)
+		name = strings.TrimSuffix(name, compressorOf(name).suffix)
+		name = checksumPrefix.ReplaceAllString(name, "")
+		files = append(files, extraMetadata{repoDir, data.Type, name, content})
+	}
+	return files, nil
+}
+
+// mergeMetadata merges the contents of files, which are all of the same
+// type: XML documents with the same root element, or YAML streams
+func mergeMetadata(files []extraMetadata) ([]byte, error) {
+	if len(files) == 1 {
+		return files[0].content, nil
+	}
+
+	isXML, isYAML := true, true
+	for _, f := range files {
+		content := bytes.TrimSpace(f.content)
+		isXML = isXML && bytes.HasPrefix(content, []byte("<"))
+		isYAML = isYAML && bytes.HasPrefix(content, []byte("---"))
+	}
+	switch {
+	case isXML:
+		return mergeXML(files)
+	case isYAML:
+		var merged []byte
+		for _, f := range files {
+			merged = append(merged, f.content...)
+			if !bytes.HasSuffix(merged, []byte("\n")) {
+				merged = append(merged, '\n')
+			}
+		}
+		return merged, nil
+	}
+	return nil, errors.New("neither XML nor YAML")
+}
+
+// xmlDocument is a XML document split around the children of its root
+type xmlDocument struct {
+	root string
+	// head is everything up to the end of the start tag of the root
+	head     []byte
+	children []xmlChild
+	// tail is everything from the end tag of the root
+	tail []byte
+}
+
+// xmlChild is an element directly below the root of a XML document
+type xmlChild struct {
+	name string
+	// id is the text of its <id> element, empty if it has none
+	id  string
+	raw []byte
+}
+
+// key identifies the element among the children of the root
+func (c xmlChild) key() string {
+	if c.id != "" {
+		return c.name + "\x00" + c.id
+	}
+	return c.name + "\x00" + string(c.raw)
+}
+
+// splitXML splits content into a xmlDocument, keeping the bytes of the
+// children as they are
+func splitXML(content []byte) (*xmlDocument, error) {
+	d := xml.NewDecoder(bytes.NewReader(content))
+	doc := xmlDocument{}
+	depth := 0
+	var child *xmlChild
+	var childStart int64
+	inID := false
+	for {
+		offset := d.InputOffset()
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				doc.root = t.Name.Local
+				doc.head = content[:d.InputOffset()]
+			case 2:
+				child = &xmlChild{name: t.Name.Local}
+				childStart = offset
+			case 3:
+				inID = t.Name.Local == "id"
+			}
+		case xml.CharData:
+			if inID {
+				child.id += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			switch depth {
+			case 1:
+				doc.tail = content[offset:]
+			case 2:
+				child.raw = content[childStart:d.InputOffset()]
+				doc.children = append(doc.children, *child)
+			case 3:
+				inID = false
+			}
+			depth--
+		}
+	}
+
+	if doc.root == "" || doc.tail == nil {
+		return nil, errors.New("no root element")
+	}
+	return &doc, nil
+}
+
+// mergeXML merges the children of the roots of the XML documents of files
+// into the first one. Of the elements with the same name and <id>, or the
+// same content if they have no id, only the first one is kept.
+func mergeXML(files []extraMetadata) ([]byte, error) {
+	var merged *xmlDocument
+	seen := make(map[string]bool)
+	for _, f := range files {
+		doc, err := splitXML(f.content)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s of %s: %s", f.name, f.repoDir, err.Error()))
+		}
+		if merged == nil {
+			merged = &xmlDocument{root: doc.root, head: doc.head, tail: doc.tail}
+		} else if doc.root != merged.root {
+			return nil, errors.New(fmt.Sprintf("%s of %s is a <%s>, not a <%s>", f.name, f.repoDir, doc.root, merged.root))
+		}
+
+		for _, child := range doc.children {
+			if !seen[child.key()] {
+				seen[child.key()] = true
+				merged.children = append(merged.children, child)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(merged.head)
+	for _, child := range merged.children {
+		out.WriteString("\n  ")
+		out.Write(child.raw)
+	}
+	out.WriteString("\n")
+	out.Write(merged.tail)
+	return out.Bytes(), nil
+}
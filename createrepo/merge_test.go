@@ -0,0 +1,113 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// newMergeSource returns a repository with openssl.rpm at name and comps
+// with the given groups
+func newMergeSource(t *testing.T, name string, groups ...string) string {
+	dir := newTestRepo(t, name)
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	comps := "<?xml version=\"1.0\"?>\n<comps>\n"
+	for _, group := range groups {
+		comps += "  <group>\n    <id>" + strings.Split(group, ":")[0] + "</id>\n    <name>" + group + "</name>\n  </group>\n"
+	}
+	comps += "</comps>\n"
+	path := filepath.Join(dir, "comps.xml")
+	if err = ioutil.WriteFile(path, []byte(comps), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = AddMetadata(filepath.Join(dir, "repodata"), path, "group", "sha256", "gz"); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMerge(t *testing.T) {
+	a := newMergeSource(t, "openssl.rpm", "core:a")
+	defer os.RemoveAll(a)
+	b := newMergeSource(t, "Packages/openssl.rpm", "core:b", "devel:b")
+	defer os.RemoveAll(b)
+	out, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	sources := []MergeSource{{Dir: a, BaseURL: "http://example.com/a/"}, {Dir: b}}
+	tests := []struct {
+		policy   string
+		packages []string
+	}{
+		{"first", []string{"http://example.com/a/openssl.rpm"}},
+		{"newest", []string{"http://example.com/a/openssl.rpm"}},
+		// sorted by location_href
+		{"all", []string{"file://" + filepath.ToSlash(b) + "/Packages/openssl.rpm", "http://example.com/a/openssl.rpm"}},
+	}
+	for _, test := range tests {
+		result, err := Merge(context.Background(), out, sources, MergeOptions{Policy: test.policy})
+		if err != nil {
+			t.Fatalf("%s: Merge() failed: %s", test.policy, err.Error())
+		}
+		if result.Packages != len(test.packages) || len(result.Duplicates) != 1 || !result.Duplicates[0].Identical {
+			t.Errorf("%s: wrong result %+v", test.policy, result)
+		}
+
+		packages, err := loadPackages(out, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var locations []string
+		for _, p := range packages {
+			locations = append(locations, p.location())
+		}
+		shouldEqualStr(t, test.policy, strings.Join(locations, " "), strings.Join(test.packages, " "))
+	}
+
+	files, err := readExtraMetadata(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].mdType != "group" || files[0].name != "comps.xml" {
+		t.Fatalf("expected the merged comps.xml, got %+v", files)
+	}
+	comps := string(files[0].content)
+	if !strings.Contains(comps, "<name>core:a</name>") || strings.Contains(comps, "core:b") || !strings.Contains(comps, "<name>devel:b</name>") {
+		t.Errorf("groups not merged by id:\n%s", comps)
+	}
+
+	if _, err = Merge(context.Background(), out, sources, MergeOptions{Policy: "last"}); err == nil {
+		t.Error("an unknown policy should be rejected")
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	yaml := []extraMetadata{{content: []byte("---\na: 1\n...")}, {content: []byte("---\nb: 2\n...\n")}}
+	merged, err := mergeMetadata(yaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shouldEqualStr(t, "YAML", string(merged), "---\na: 1\n...\n---\nb: 2\n...\n")
+
+	xml := []extraMetadata{{content: []byte("<updates><update><id>A</id></update></updates>")}, {content: []byte("<comps/>")}}
+	if _, err = mergeMetadata(xml); err == nil {
+		t.Error("documents with different roots should not be merged")
+	}
+	if _, err = mergeMetadata(append(yaml, xml[0])); err == nil {
+		t.Error("YAML and XML should not be merged")
+	}
+}
@@ -0,0 +1,439 @@
+package createrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// metadataDB is a sqlite database of the repodata
+type metadataDB struct {
+	mdType string
+	// file is the name of the uncompressed database
+	file    string
+	sqlInit string
+	// fill inserts the packages into the database
+	fill func(tx *sql.Tx, packages []locatedPackage) error
+}
+
+// metadataDBs are the databases written for every repository
+var metadataDBs = []metadataDB{
+	{"primary_db", "primary.sqlite", sqlInitPrimaryDB, fillPrimaryDB},
+	{"filelists_db", "filelists.sqlite", sqlInitFilelistsDB, fillFilelistsDB},
+	{"other_db", "other.sqlite", sqlInitOtherDB, fillOtherDB},
+}
+
+// writeRepodata writes the metadata files and repomd.xml into dir. The
+// packages are sorted by location_href, so that the metadata does not depend
+// on the order they arrive in. It returns an error if ctx is canceled before
+// c is drained.
+func (repo *repository) writeRepodata(ctx context.Context, dir string, c <-chan *packageInfo) error {
+	canceled := func() error {
+		return errors.New(fmt.Sprintf("generating metadata canceled: %s", ctx.Err().Error()))
+	}
+
+	packages, err := repo.sortPackages(ctx, c)
+	if err != nil {
+		return err
+	}
+	// the producers also stop on cancellation, which closes c early
+	if ctx.Err() != nil {
+		return canceled()
+	}
+
+	checksum, err := contentChecksum(packageInfos(packages), repo.checksumType)
+	if err != nil {
+		return err
+	}
+
+	md := newRepomd()
+	for _, mdb := range metadataDBs {
+		path := filepath.Join(dir, mdb.file)
+		if err = writeMetadataDB(path, mdb, packages, checksum); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return canceled()
+		}
+
+		data, err := addMetadataFile(path, mdb.mdType, repo.checksumType, repo.compression)
+		if err != nil {
+			return err
+		}
+		data.DatabaseVersion = repoDBVersion
+		md.set(data)
+	}
+	return md.write(dir)
+}
+
+// writeMetadataDB creates the database at dbPath and fills it in a single
+// transaction
+func writeMetadataDB(dbPath string, mdb metadataDB, packages []locatedPackage, checksum string) error {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err = initDB(db, mdb.sqlInit); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = mdb.fill(tx, packages); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return finishDB(db, checksum)
+}
+
+// nullString returns nil for an empty string, which is stored as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// pkgKey returns the pkgKey of the i-th package, the same in all databases
+func pkgKey(i int) int64 {
+	return int64(i + 1)
+}
+
+func fillPrimaryDB(tx *sql.Tx, packages []locatedPackage) error {
+	placeHolders := strings.Join(repeatStr(26, "?"), ",")
+	packageStmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO packages (pkgKey, %s) values (%s)", primaryColumns, placeHolders))
+	if err != nil {
+		return err
+	}
+	defer packageStmt.Close()
+
+	depStmts := make(map[string]*sql.Stmt)
+	for _, table := range []string{"provides", "conflicts", "obsoletes"} {
+		stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (name, flags, epoch, version, release, pkgKey) values (?,?,?,?,?,?)", table))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		depStmts[table] = stmt
+	}
+	requireStmt, err := tx.Prepare("INSERT INTO requires (name, flags, epoch, version, release, pkgKey, pre) values (?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer requireStmt.Close()
+	fileStmt, err := tx.Prepare("INSERT INTO files (name, type, pkgKey) values (?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer fileStmt.Close()
+
+	for i, p := range packages {
+		key := pkgKey(i)
+		values := append([]interface{}{key}, p.info.primaryValues(p.locationHref)...)
+		if _, err = packageStmt.Exec(values...); err != nil {
+			return err
+		}
+
+		deps := map[string][]dependency{
+			"provides":  p.info.provides,
+			"conflicts": p.info.conflicts,
+			"obsoletes": p.info.obsoletes,
+		}
+		for table, list := range deps {
+			for _, d := range list {
+				_, err = depStmts[table].Exec(d.name, nullString(d.flags), nullString(d.epoch), nullString(d.version), nullString(d.release), key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, d := range p.info.requires {
+			_, err = requireStmt.Exec(d.name, nullString(d.flags), nullString(d.epoch), nullString(d.version), nullString(d.release), key, d.pre)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, f := range p.info.files {
+			if !isPrimaryFile(f.name) {
+				continue
+			}
+			if _, err = fileStmt.Exec(f.name, f.typ, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fillFilelistsDB(tx *sql.Tx, packages []locatedPackage) error {
+	packageStmt, err := tx.Prepare("INSERT INTO packages (pkgKey, pkgId) values (?,?)")
+	if err != nil {
+		return err
+	}
+	defer packageStmt.Close()
+	filelistStmt, err := tx.Prepare("INSERT INTO filelist (pkgKey, dirname, filenames, filetypes) values (?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer filelistStmt.Close()
+
+	for i, p := range packages {
+		key := pkgKey(i)
+		if _, err = packageStmt.Exec(key, p.info.checksum); err != nil {
+			return err
+		}
+
+		dirs, names, types := splitFilelist(p.info.files)
+		for _, dir := range dirs {
+			if _, err = filelistStmt.Exec(key, dir, names[dir], types[dir]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fillOtherDB(tx *sql.Tx, packages []locatedPackage) error {
+	packageStmt, err := tx.Prepare("INSERT INTO packages (pkgKey, pkgId) values (?,?)")
+	if err != nil {
+		return err
+	}
+	defer packageStmt.Close()
+	changelogStmt, err := tx.Prepare("INSERT INTO changelog (pkgKey, author, date, changelog) values (?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer changelogStmt.Close()
+
+	for i, p := range packages {
+		key := pkgKey(i)
+		if _, err = packageStmt.Exec(key, p.info.checksum); err != nil {
+			return err
+		}
+
+		for _, c := range p.info.changelogs {
+			if _, err = changelogStmt.Exec(key, c.author, c.date, c.text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadPackages reads the packages of the repodata of dir, with their
+// dependencies, files and changelogs. Their paths are resolved against
+// baseDir. The sqlite databases are read if there are any, like those
+// written by createrepo_c, otherwise the XML metadata.
+func loadPackages(dir string, baseDir string) ([]*packageInfo, error) {
+	md, err := readRepomd(filepath.Join(dir, "repodata"))
+	if err != nil {
+		return nil, err
+	}
+	if md.find("primary_db") == nil && md.find("primary") != nil {
+		return loadXMLPackages(dir, md, baseDir)
+	}
+	return loadDBPackages(dir, baseDir)
+}
+
+// loadDBPackages reads the packages of the sqlite databases of dir
+func loadDBPackages(dir string, baseDir string) ([]*packageInfo, error) {
+	db, closeDB, err := openMetadataDB(dir, "primary_db")
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+
+	packages, err := readPackages(db, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[int64]*packageInfo, len(packages))
+	for _, p := range packages {
+		byKey[p.pkgKey] = p
+	}
+
+	if err = readDependencies(db, byKey); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string][]*packageInfo, len(packages))
+	for _, p := range packages {
+		byID[p.checksum] = append(byID[p.checksum], p)
+	}
+
+	filelists, closeFilelists, err := openMetadataDB(dir, "filelists_db")
+	if err != nil {
+		return nil, err
+	}
+	defer closeFilelists()
+	keys, err := packageKeys(filelists, byID)
+	if err != nil {
+		return nil, err
+	}
+	if err = readFilelists(filelists, keys); err != nil {
+		return nil, err
+	}
+
+	other, closeOther, err := openMetadataDB(dir, "other_db")
+	if err != nil {
+		return nil, err
+	}
+	defer closeOther()
+	if keys, err = packageKeys(other, byID); err != nil {
+		return nil, err
+	}
+	if err = readChangelogs(other, keys); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// packageKeys maps the pkgKeys of the packages table of a filelists or
+// other database to the packages of the primary database with the same
+// pkgId. A pkgKey is only valid within its database, they merely match
+// across the databases written by createrepo-lite. Packages with the same
+// pkgId are the same RPM, so the first entry of a pkgId is used for all.
+func packageKeys(db *sql.DB, byID map[string][]*packageInfo) (map[int64][]*packageInfo, error) {
+	rows, err := db.Query("SELECT pkgKey, pkgId FROM packages ORDER BY pkgKey")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[int64][]*packageInfo)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var key int64
+		var pkgID string
+		if err = rows.Scan(&key, &pkgID); err != nil {
+			return nil, err
+		}
+		if !seen[pkgID] {
+			seen[pkgID] = true
+			keys[key] = byID[pkgID]
+		}
+	}
+	return keys, rows.Err()
+}
+
+// readDependencies reads the provides, requires, conflicts and obsoletes of
+// the primary database into the packages indexed by pkgKey
+func readDependencies(db *sql.DB, byKey map[int64]*packageInfo) error {
+	for _, table := range []string{"provides", "requires", "conflicts", "obsoletes"} {
+		pre := "0"
+		if table == "requires" {
+			pre = "pre"
+		}
+		rows, err := db.Query(fmt.Sprintf("SELECT pkgKey, name, flags, epoch, version, release, %s FROM %s ORDER BY rowid", pre, table))
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var key int64
+			var d dependency
+			var flags, epoch, version, release sql.NullString
+			if err = rows.Scan(&key, &d.name, &flags, &epoch, &version, &release, &d.pre); err != nil {
+				rows.Close()
+				return err
+			}
+			d.flags, d.epoch, d.version, d.release = flags.String, epoch.String, version.String, release.String
+
+			p, ok := byKey[key]
+			if !ok {
+				continue
+			}
+			switch table {
+			case "provides":
+				p.provides = append(p.provides, d)
+			case "requires":
+				p.requires = append(p.requires, d)
+			case "conflicts":
+				p.conflicts = append(p.conflicts, d)
+			case "obsoletes":
+				p.obsoletes = append(p.obsoletes, d)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFilelists reads the files of the filelists database into the packages
+// of its pkgKeys, see packageKeys
+func readFilelists(db *sql.DB, keys map[int64][]*packageInfo) error {
+	rows, err := db.Query("SELECT pkgKey, dirname, filenames, filetypes FROM filelist ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	typeNames := map[byte]string{'f': "file", 'd': "dir", 'g': "ghost"}
+	for rows.Next() {
+		var key int64
+		var dir, names, types string
+		if err = rows.Scan(&key, &dir, &names, &types); err != nil {
+			return err
+		}
+
+		for i, name := range strings.Split(names, "/") {
+			f := packageFile{name: strings.TrimSuffix(dir, "/") + "/" + name, typ: "file"}
+			if i < len(types) && typeNames[types[i]] != "" {
+				f.typ = typeNames[types[i]]
+			}
+			for _, p := range keys[key] {
+				p.files = append(p.files, f)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, packages := range keys {
+		for _, p := range packages {
+			sortFiles(p.files)
+		}
+	}
+	return nil
+}
+
+// readChangelogs reads the changelogs of the other database into the
+// packages of its pkgKeys, see packageKeys
+func readChangelogs(db *sql.DB, keys map[int64][]*packageInfo) error {
+	rows, err := db.Query("SELECT pkgKey, author, date, changelog FROM changelog ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key int64
+		var c changelog
+		if err = rows.Scan(&key, &c.author, &c.date, &c.text); err != nil {
+			return err
+		}
+		for _, p := range keys[key] {
+			p.changelogs = append(p.changelogs, c)
+		}
+	}
+	return rows.Err()
+}
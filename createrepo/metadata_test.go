@@ -0,0 +1,141 @@
+package createrepo
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPackageDependencies(t *testing.T) {
+	ts := newTS()
+	defer ts.close()
+
+	info, err := ts.parsePackageInfo("openssl.rpm", "sha256")
+	if err != nil {
+		t.Fatal("parsePackageInfo(openssl.rpm) failed:", err.Error())
+	}
+
+	counts := map[string]int{
+		"provides":   len(info.provides),
+		"requires":   len(info.requires),
+		"files":      len(info.files),
+		"changelogs": len(info.changelogs),
+	}
+	expected := map[string]int{"provides": 55, "requires": 30, "files": 107, "changelogs": 303}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("wrong number of entries: %v, expected %v", counts, expected)
+	}
+
+	config := dependency{name: "config(openssl)", flags: "EQ", epoch: "0", version: "1.0.1e", release: "30.el6_6.5"}
+	if !reflect.DeepEqual(info.provides[0], config) {
+		t.Errorf("wrong first provide %+v", info.provides[0])
+	}
+	for _, d := range info.requires {
+		if d.name == "rpmlib(CompressedFileNames)" {
+			t.Error("rpmlib() requires should be left out")
+		}
+	}
+	shouldEqualStr(t, "changelog author", info.changelogs[0].author, "Tomáš Mráz <tmraz@redhat.com> 1.0.1e-30.5")
+}
+
+func TestLoadPackagesRoundTrip(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	ts := newTS()
+	defer ts.close()
+	parsed, err := ts.parsePackageInfo(dir+"/openssl.rpm", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packages, err := loadPackages(dir, dir)
+	if err != nil {
+		t.Fatal("loadPackages failed:", err.Error())
+	}
+	if len(packages) != 1 {
+		t.Fatalf("expected 1 package, got %d", len(packages))
+	}
+
+	loaded := packages[0]
+	shouldEqualStr(t, "locationHref", loaded.locationHref, "openssl.rpm")
+	loaded.pkgKey, loaded.locationHref = 0, ""
+	if !reflect.DeepEqual(loaded, parsed) {
+		t.Errorf("the package read from the repodata differs from the RPM:\n%+v\n%+v", loaded, parsed)
+	}
+}
+
+func TestLoadPackagesMismatchedKeys(t *testing.T) {
+	dir := newTestRepo(t, "a.rpm", "b.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir, WithCompression("none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	// other tools number the packages of each database on their own: move
+	// the packages of the filelists and other databases to other pkgKeys
+	// and give their former pkgKeys to a package missing from primary
+	md, err := readRepomd(filepath.Join(dir, "repodata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mdType := range []string{"filelists_db", "other_db"} {
+		db, err := sql.Open("sqlite3", filepath.Join(dir, filepath.FromSlash(md.find(mdType).Location.Href)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		statements := []string{
+			"UPDATE packages SET pkgKey = pkgKey + 100",
+			"INSERT INTO packages (pkgKey, pkgId) VALUES (1, 'unknown'), (2, 'unknown')",
+		}
+		if mdType == "filelists_db" {
+			statements = append(statements,
+				"UPDATE filelist SET pkgKey = pkgKey + 100",
+				"INSERT INTO filelist VALUES (1, '/unknown', 'file', 'f'), (2, '/unknown', 'file', 'f')")
+		} else {
+			statements = append(statements,
+				"UPDATE changelog SET pkgKey = pkgKey + 100",
+				"INSERT INTO changelog VALUES (1, 'unknown', 0, 'unknown'), (2, 'unknown', 0, 'unknown')")
+		}
+		for _, statement := range statements {
+			if _, err = db.Exec(statement); err != nil {
+				t.Fatal(statement, "failed:", err.Error())
+			}
+		}
+		db.Close()
+	}
+
+	packages, err := loadPackages(dir, dir)
+	if err != nil {
+		t.Fatal("loadPackages failed:", err.Error())
+	}
+	if len(packages) != 2 {
+		t.Fatalf("expected 2 packages, got %d", len(packages))
+	}
+	for _, p := range packages {
+		if len(p.files) != 107 || len(p.changelogs) != 303 {
+			t.Errorf("%s: %d files and %d changelogs, expected 107 and 303", p.locationHref, len(p.files), len(p.changelogs))
+		}
+		for _, f := range p.files {
+			if f.name == "/unknown/file" {
+				t.Errorf("%s got the files of another package", p.locationHref)
+			}
+		}
+	}
+}
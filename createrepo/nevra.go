@@ -0,0 +1,214 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+// NEVRA identifies a build of a package: name, epoch, version, release and
+// architecture
+type NEVRA struct {
+	Name string
+	// Epoch is empty if the package has none, which is the same as "0"
+	Epoch   string
+	Version string
+	Release string
+	Arch    string
+}
+
+// ParseNEVRA parses a file name like "bash-0:5.1.8-6.el9.x86_64.rpm". The
+// directory, the .rpm suffix and the epoch are optional, the epoch may also
+// be a prefix of the name as in "1:bash-5.1.8-6.el9.x86_64".
+func ParseNEVRA(filename string) (NEVRA, error) {
+	s := strings.TrimSuffix(filepath.Base(filename), ".rpm")
+	invalid := errors.New(fmt.Sprintf("invalid package file name: %s", filename))
+
+	var n NEVRA
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return n, invalid
+	}
+	n.Arch, s = s[i+1:], s[:i]
+
+	i = strings.LastIndex(s, "-")
+	if i < 0 {
+		return n, invalid
+	}
+	n.Release, s = s[i+1:], s[:i]
+
+	i = strings.LastIndex(s, "-")
+	if i < 0 {
+		return n, invalid
+	}
+	n.Version, n.Name = s[i+1:], s[:i]
+
+	if i = strings.Index(n.Version, ":"); i >= 0 {
+		n.Epoch, n.Version = n.Version[:i], n.Version[i+1:]
+	} else if i = strings.Index(n.Name, ":"); i >= 0 {
+		n.Epoch, n.Name = n.Name[:i], n.Name[i+1:]
+	}
+
+	if n.Name == "" || n.Version == "" || n.Release == "" || n.Arch == "" {
+		return n, invalid
+	}
+	if n.Epoch != "" {
+		if _, err := strconv.ParseUint(n.Epoch, 10, 32); err != nil {
+			return n, invalid
+		}
+	}
+	return n, nil
+}
+
+// String returns name-[epoch:]version-release.arch, the epoch being omitted
+// if it is empty
+func (n NEVRA) String() string {
+	if n.Epoch == "" {
+		return fmt.Sprintf("%s-%s-%s.%s", n.Name, n.Version, n.Release, n.Arch)
+	}
+	return fmt.Sprintf("%s-%s:%s-%s.%s", n.Name, n.Epoch, n.Version, n.Release, n.Arch)
+}
+
+// EVR returns [epoch:]version-release
+func (n NEVRA) EVR() string {
+	if n.Epoch == "" {
+		return n.Version + "-" + n.Release
+	}
+	return n.Epoch + ":" + n.Version + "-" + n.Release
+}
+
+// nevra returns the NEVRA of the package
+func (p *packageInfo) nevra() NEVRA {
+	return NEVRA{p.rpmName, p.rpmEpoch, p.rpmVersion, p.rpmRelease, p.rpmArch}
+}
+
+// LabelCompare compares the epoch, version and release of a and b like rpm
+// does, ignoring the name and the architecture. It returns -1 if a is older
+// than b, 0 if they are equal and 1 if a is newer.
+func LabelCompare(a NEVRA, b NEVRA) int {
+	if rc := compareEpoch(a.Epoch, b.Epoch); rc != 0 {
+		return rc
+	}
+	if rc := Vercmp(a.Version, b.Version); rc != 0 {
+		return rc
+	}
+	return Vercmp(a.Release, b.Release)
+}
+
+// compareEpoch compares two epochs numerically, an empty epoch being 0
+func compareEpoch(a string, b string) int {
+	x, _ := strconv.ParseUint(a, 10, 64)
+	y, _ := strconv.ParseUint(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// Vercmp compares two version or release strings like rpmvercmp(3). They are
+// split into segments of digits and of letters, separated by any other
+// characters. Numeric segments compare as numbers and are newer than
+// alphabetic ones. "~" sorts before anything, even the end of the string,
+// which makes 1.0~rc1 older than 1.0, while "^" sorts after the end of the
+// string but before any other segment, so 1.0 < 1.0^git1 < 1.0.1.
+func Vercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	for len(a) > 0 || len(b) > 0 {
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case len(a) == 0:
+				return -1
+			case len(b) == 0:
+				return 1
+			case a[0] != '^':
+				return 1
+			case b[0] != '^':
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		isNum := isDigit(a[0])
+		segA, segB := a, b
+		if isNum {
+			a = strings.TrimLeftFunc(a, isDigitRune)
+			b = strings.TrimLeftFunc(b, isDigitRune)
+		} else {
+			a = strings.TrimLeftFunc(a, isAlphaRune)
+			b = strings.TrimLeftFunc(b, isAlphaRune)
+		}
+		segA, segB = segA[:len(segA)-len(a)], segB[:len(segB)-len(b)]
+
+		// the segments are of different types, numbers are newer
+		if len(segB) == 0 {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if rc := strings.Compare(segA, segB); rc != 0 {
+			return rc
+		}
+	}
+
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	}
+	return 1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDigitRune(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isAlphaRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// isSeparator returns true for the characters between segments
+func isSeparator(r rune) bool {
+	return !isDigitRune(r) && !isAlphaRune(r) && r != '~' && r != '^'
+}
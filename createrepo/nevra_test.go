@@ -0,0 +1,160 @@
+package createrepo
+
+import "testing"
+
+// vercmpTests are the test vectors of rpm's tests/rpmvercmp.at
+var vercmpTests = []struct {
+	a, b     string
+	expected int
+}{
+	{"1.0", "1.0", 0},
+	{"1.0", "2.0", -1},
+	{"2.0", "1.0", 1},
+	{"2.0.1", "2.0.1", 0},
+	{"2.0", "2.0.1", -1},
+	{"2.0.1", "2.0", 1},
+	{"2.0.1a", "2.0.1a", 0},
+	{"2.0.1a", "2.0.1", 1},
+	{"2.0.1", "2.0.1a", -1},
+	{"5.5p1", "5.5p1", 0},
+	{"5.5p1", "5.5p2", -1},
+	{"5.5p2", "5.5p1", 1},
+	{"5.5p10", "5.5p10", 0},
+	{"5.5p1", "5.5p10", -1},
+	{"5.5p10", "5.5p1", 1},
+	{"10xyz", "10.1xyz", -1},
+	{"10.1xyz", "10xyz", 1},
+	{"xyz10", "xyz10", 0},
+	{"xyz10", "xyz10.1", -1},
+	{"xyz10.1", "xyz10", 1},
+	{"xyz.4", "xyz.4", 0},
+	{"xyz.4", "8", -1},
+	{"8", "xyz.4", 1},
+	{"xyz.4", "2", -1},
+	{"2", "xyz.4", 1},
+	{"5.5p2", "5.6p1", -1},
+	{"5.6p1", "5.5p2", 1},
+	{"5.6p1", "6.5p1", -1},
+	{"6.5p1", "5.6p1", 1},
+	{"6.0.rc1", "6.0", 1},
+	{"6.0", "6.0.rc1", -1},
+	{"10b2", "10a1", 1},
+	{"10a2", "10b2", -1},
+	{"1.0aa", "1.0aa", 0},
+	{"1.0a", "1.0aa", -1},
+	{"1.0aa", "1.0a", 1},
+	{"10.0001", "10.0001", 0},
+	{"10.0001", "10.1", 0},
+	{"10.1", "10.0001", 0},
+	{"10.0001", "10.0039", -1},
+	{"10.0039", "10.0001", 1},
+	{"4.999.9", "5.0", -1},
+	{"5.0", "4.999.9", 1},
+	{"20101121", "20101121", 0},
+	{"20101121", "20101122", -1},
+	{"20101122", "20101121", 1},
+	{"2_0", "2_0", 0},
+	{"2.0", "2_0", 0},
+	{"2_0", "2.0", 0},
+	{"a", "a", 0},
+	{"a+", "a+", 0},
+	{"a+", "a_", 0},
+	{"a_", "a+", 0},
+	{"+a", "+a", 0},
+	{"+a", "_a", 0},
+	{"_a", "+a", 0},
+	{"+_", "+_", 0},
+	{"_+", "+_", 0},
+	{"_+", "_+", 0},
+	{"+", "_", 0},
+	{"_", "+", 0},
+	{"1.0~rc1", "1.0~rc1", 0},
+	{"1.0~rc1", "1.0", -1},
+	{"1.0", "1.0~rc1", 1},
+	{"1.0~rc1", "1.0~rc2", -1},
+	{"1.0~rc2", "1.0~rc1", 1},
+	{"1.0~rc1~git123", "1.0~rc1~git123", 0},
+	{"1.0~rc1~git123", "1.0~rc1", -1},
+	{"1.0~rc1", "1.0~rc1~git123", 1},
+	{"1.0^", "1.0^", 0},
+	{"1.0^", "1.0", 1},
+	{"1.0", "1.0^", -1},
+	{"1.0^git1", "1.0^git1", 0},
+	{"1.0^git1", "1.0", 1},
+	{"1.0", "1.0^git1", -1},
+	{"1.0^git1", "1.0^git2", -1},
+	{"1.0^git2", "1.0^git1", 1},
+	{"1.0^git1", "1.01", -1},
+	{"1.01", "1.0^git1", 1},
+	{"1.0^20160101", "1.0^20160101", 0},
+	{"1.0^20160101", "1.0.1", -1},
+	{"1.0.1", "1.0^20160101", 1},
+	{"1.0^20160101^git1", "1.0^20160101^git1", 0},
+	{"1.0^20160102", "1.0^20160101^git1", 1},
+	{"1.0^20160101^git1", "1.0^20160102", -1},
+	{"1.0~rc1^git1", "1.0~rc1^git1", 0},
+	{"1.0~rc1^git1", "1.0~rc1", 1},
+	{"1.0~rc1", "1.0~rc1^git1", -1},
+	{"1.0^git1~pre", "1.0^git1~pre", 0},
+	{"1.0^git1", "1.0^git1~pre", 1},
+	{"1.0^git1~pre", "1.0^git1", -1},
+	{"1b.fc17", "1b.fc17", 0},
+	{"1b.fc17", "1.fc17", -1},
+	{"1.fc17", "1b.fc17", 1},
+	{"1g.fc17", "1g.fc17", 0},
+	{"1g.fc17", "1.fc17", 1},
+	{"1.fc17", "1g.fc17", -1},
+}
+
+func TestVercmp(t *testing.T) {
+	for _, test := range vercmpTests {
+		if rc := Vercmp(test.a, test.b); rc != test.expected {
+			t.Errorf("Vercmp(%q, %q) = %d, expected %d", test.a, test.b, rc, test.expected)
+		}
+	}
+}
+
+func TestLabelCompare(t *testing.T) {
+	tests := []struct {
+		a, b     NEVRA
+		expected int
+	}{
+		{NEVRA{Version: "1.0", Release: "1"}, NEVRA{Epoch: "0", Version: "1.0", Release: "1"}, 0},
+		{NEVRA{Epoch: "1", Version: "1.0", Release: "1"}, NEVRA{Version: "2.0", Release: "1"}, 1},
+		{NEVRA{Epoch: "2", Version: "1.0", Release: "1"}, NEVRA{Epoch: "10", Version: "1.0", Release: "1"}, -1},
+		{NEVRA{Version: "1.0", Release: "2.el9"}, NEVRA{Version: "1.0", Release: "10.el9"}, -1},
+		{NEVRA{Version: "1.0", Release: "1"}, NEVRA{Version: "1.0~rc1", Release: "9"}, 1},
+	}
+	for _, test := range tests {
+		if rc := LabelCompare(test.a, test.b); rc != test.expected {
+			t.Errorf("LabelCompare(%s, %s) = %d, expected %d", test.a.EVR(), test.b.EVR(), rc, test.expected)
+		}
+	}
+}
+
+func TestParseNEVRA(t *testing.T) {
+	tests := map[string]NEVRA{
+		"/srv/repo/bash-5.1.8-6.el9.x86_64.rpm": {"bash", "", "5.1.8", "6.el9", "x86_64"},
+		"perl-Foo-Bar-4:1.2-3.noarch.rpm":       {"perl-Foo-Bar", "4", "1.2", "3", "noarch"},
+		"1:openssl-1.0.1e-30.el6_6.11.x86_64":   {"openssl", "1", "1.0.1e", "30.el6_6.11", "x86_64"},
+		"kernel-5.14.0-70.el9.src.rpm":          {"kernel", "", "5.14.0", "70.el9", "src"},
+		"foo-1.0~rc1^git2-1.fc38.aarch64.rpm":   {"foo", "", "1.0~rc1^git2", "1.fc38", "aarch64"},
+	}
+	for filename, expected := range tests {
+		n, err := ParseNEVRA(filename)
+		if err != nil {
+			t.Error(err)
+		} else if n != expected {
+			t.Errorf("ParseNEVRA(%s) = %#v, expected %#v", filename, n, expected)
+		}
+	}
+
+	for _, filename := range []string{"foo.rpm", "foo-1.0.x86_64.rpm", "x:foo-1.0-1.x86_64.rpm", "-1.0-1.x86_64.rpm"} {
+		if n, err := ParseNEVRA(filename); err == nil {
+			t.Errorf("ParseNEVRA(%s) should fail, got %s", filename, n)
+		}
+	}
+
+	n := NEVRA{"openssl", "1", "1.0.1e", "30.el6_6.11", "x86_64"}
+	shouldEqualStr(t, "String()", n.String(), "openssl-1:1.0.1e-30.el6_6.11.x86_64")
+}
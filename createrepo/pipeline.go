@@ -0,0 +1,364 @@
+package createrepo
+
+import "fmt"
+import "os"
+import "errors"
+import "log"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+import "golang.org/x/net/context"
+
+// parsePackage returns the packageInfo of the RPM at path. The entry in cache
+// is reused if the file has not changed since it was indexed.
+func (repo *repository) parsePackage(ts rpmts, path string, cache map[string]*packageInfo) (*packageInfo, error) {
+	if cached, ok := cache[path]; ok && cached.checksumType == repo.checksumType {
+		fileInfo, err := os.Stat(path)
+		if err == nil && uint64(fileInfo.Size()) == cached.fileSize && uint32(fileInfo.ModTime().Unix()) == cached.fileTime {
+			return cached, nil
+		}
+	}
+
+	return ts.parsePackageInfo(path, repo.checksumType)
+}
+
+func parseRPMFiles(ctx context.Context, repo *repository, in <-chan string, cache map[string]*packageInfo) (<-chan *packageInfo, <-chan failure) {
+	out := make(chan *packageInfo)
+	errs := make(chan failure)
+
+	workers := repo.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			ts := newTS()
+			defer ts.close()
+			defer wg.Done()
+			for path := range in {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					info, err := repo.parsePackage(ts, path, cache)
+					if err != nil {
+						errs <- failure{path, err}
+						continue
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case out <- info:
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+		close(errs)
+	}()
+
+	return out, errs
+}
+
+// isExcluded returns true if path, relative to dir, or its base name matches
+// one of the glob patterns in excludes.
+func isExcluded(dir string, path string, excludes []string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = path
+	}
+
+	for _, pattern := range excludes {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeRPM returns true if the file name has a RPM suffix in any case
+func looksLikeRPM(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".rpm")
+}
+
+// findRPMFiles sends the absolute path of every RPM of repo, either found
+// by walking its base directory or taken from its package list. RPMs are
+// recognized by their lead rather than their name.
+func findRPMFiles(ctx context.Context, repo *repository) (<-chan string, <-chan failure) {
+	files := make(chan string)
+	errs := make(chan failure)
+
+	go func() {
+		defer close(files)
+		defer close(errs)
+
+		dir, err := filepath.Abs(repo.baseDir)
+		if err != nil {
+			errs <- failure{repo.baseDir, err}
+			return
+		}
+
+		canceled := func() error {
+			return errors.New(fmt.Sprintf("finding RPM files in %s canceled", dir))
+		}
+
+		visit := func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// report the unreadable path and keep walking
+				errs <- failure{path, err}
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return canceled()
+			default:
+				switch {
+				case path != dir && isExcluded(dir, path, repo.excludes):
+					log.Printf("excluded %s\n", path)
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				case info.IsDir():
+					return nil
+				case !info.Mode().IsRegular():
+					log.Printf("skipped %s: not a regular file\n", path)
+					return nil
+				}
+
+				kind, err := detectPackage(path)
+				switch {
+				case err != nil && looksLikeRPM(path):
+					// only files named like a RPM are worth a failure
+					errs <- failure{path, err}
+					return nil
+				case err != nil:
+					log.Printf("skipped %s: %s\n", path, err.Error())
+					return nil
+				case kind == deltaPackage:
+					log.Printf("skipped %s: %s\n", path, kind)
+					return nil
+				}
+
+				select {
+				case files <- path:
+				case <-ctx.Done():
+					return canceled()
+				}
+			}
+
+			return nil
+		}
+
+		if repo.pkglist != nil {
+			err = visitPackageList(dir, repo.pkglist, repo.followSymlinks, visit)
+		} else {
+			err = walk(dir, repo.followSymlinks, visit)
+		}
+		if err != nil && ctx.Err() == nil {
+			errs <- failure{dir, err}
+		}
+	}()
+	return files, errs
+}
+
+// visitPackageList calls fn for each path in pkglist like walk does for the
+// files it finds. Relative paths are relative to dir, and paths outside of
+// dir are rejected.
+func visitPackageList(dir string, pkglist []string, followSymlinks bool, fn filepath.WalkFunc) error {
+	for _, path := range pkglist {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && (rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+			err = errors.New(fmt.Sprintf("not in %s", dir))
+		}
+		if err != nil {
+			if err = fn(path, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if !followSymlinks {
+				log.Printf("skipped symlink %s\n", path)
+				continue
+			}
+			info, err = os.Stat(path)
+		}
+
+		if err = fn(path, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+func repeatStr(n uint32, x string) []string {
+	v := make([]string, n)
+	for i, _ := range v {
+		v[i] = x
+	}
+
+	return v
+}
+
+// genMetadata writes the repodata of every output directory of repo from
+// the packages received from c, each package going to the directories
+// repo.route() returns. Everything is built in staging directories which only
+// replace the repodata/ directories once all of them are complete, so
+// nothing is left behind on errors or cancellation.
+func (repo *repository) genMetadata(ctx context.Context, c <-chan *packageInfo) error {
+	outputDirs := repo.outputDirs()
+	inputs := make(map[string]chan *packageInfo, len(outputDirs))
+	errs := make(chan error, len(outputDirs))
+	for _, dir := range outputDirs {
+		in := make(chan *packageInfo)
+		inputs[dir] = in
+		go func(dir string, in <-chan *packageInfo) {
+			err := repo.stageRepodata(ctx, dir, in)
+			// keep draining on errors so that the other directories still
+			// get their packages
+			for range in {
+			}
+			errs <- err
+		}(dir, in)
+	}
+
+	for p := range c {
+		for _, dir := range repo.route(p) {
+			inputs[dir] <- p
+		}
+	}
+	for _, in := range inputs {
+		close(in)
+	}
+
+	var err error
+	for range outputDirs {
+		if stageErr := <-errs; stageErr != nil && err == nil {
+			err = stageErr
+		}
+	}
+
+	for _, dir := range outputDirs {
+		if err == nil && repo.finishStaging != nil {
+			err = repo.finishStaging(filepath.Join(dir, ".repodata"))
+		}
+	}
+	for _, dir := range outputDirs {
+		stagingDir := filepath.Join(dir, ".repodata")
+		if err == nil {
+			err = replaceDir(stagingDir, filepath.Join(dir, "repodata"))
+		}
+		os.RemoveAll(stagingDir)
+	}
+	return err
+}
+
+// stageRepodata writes the repodata of the packages received from c into
+// the staging directory dir/.repodata
+func (repo *repository) stageRepodata(ctx context.Context, dir string, c <-chan *packageInfo) error {
+	stagingDir := filepath.Join(dir, ".repodata")
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+
+	return repo.writeRepodata(ctx, stagingDir, c)
+}
+
+// replaceDir moves the directory src to dst, replacing dst if it exists
+func replaceDir(src string, dst string) error {
+	old := dst + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+
+	if err := os.Rename(dst, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// locatedPackage is a package with its location_href in the repodata
+type locatedPackage struct {
+	info         *packageInfo
+	locationHref string
+}
+
+// sortPackages receives the packages from c and sorts them by location_href
+func (repo *repository) sortPackages(ctx context.Context, c <-chan *packageInfo) ([]locatedPackage, error) {
+	var packages []locatedPackage
+	for p := range c {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		locationHref, err := repo.locationHref(p)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, locatedPackage{p, locationHref})
+	}
+
+	// stable for merged repositories, which may have the same location in
+	// several bases
+	sort.SliceStable(packages, func(i, j int) bool {
+		return packages[i].locationHref < packages[j].locationHref
+	})
+	return packages, nil
+}
+
+// packageInfos returns the packageInfo of every located package
+func packageInfos(packages []locatedPackage) []*packageInfo {
+	infos := make([]*packageInfo, len(packages))
+	for i, p := range packages {
+		infos[i] = p.info
+	}
+	return infos
+}
+
+// loadCache returns the packages of the existing repodata in the output
+// directories of repo indexed by path, for update to skip unchanged RPMs.
+func (repo *repository) loadCache() (map[string]*packageInfo, error) {
+	cache := make(map[string]*packageInfo)
+	var err error
+	for _, dir := range repo.outputDirs() {
+		var packages []*packageInfo
+		if packages, err = loadPackages(dir, repo.baseDir); err != nil {
+			continue
+		}
+		for _, p := range packages {
+			cache[p.path] = p
+		}
+	}
+
+	if len(cache) == 0 && err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
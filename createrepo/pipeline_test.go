@@ -0,0 +1,88 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestGenMetadataCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := make(chan *packageInfo)
+	close(c)
+
+	repo := repository{baseDir: dir, outputDir: dir, checksumType: "sha256", compression: "gz"}
+	if err = repo.genMetadata(ctx, c); err == nil {
+		t.Error("genMetadata() should report the cancellation")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 0 {
+		t.Error("partial output left behind:", files)
+	}
+}
+
+func TestGenMetadataFinishStaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := repository{baseDir: dir, outputDir: dir, checksumType: "sha256", compression: "gz"}
+	empty := func() <-chan *packageInfo {
+		c := make(chan *packageInfo)
+		close(c)
+		return c
+	}
+	var staged []string
+	repo.finishStaging = func(stagingDir string) error {
+		staged = append(staged, stagingDir)
+		return ioutil.WriteFile(filepath.Join(stagingDir, "extra"), nil, 0644)
+	}
+	if err = repo.genMetadata(context.Background(), empty()); err != nil {
+		t.Fatal("genMetadata() failed:", err.Error())
+	}
+	shouldEqualStr(t, "staging dir", strings.Join(staged, " "), filepath.Join(dir, ".repodata"))
+	if _, err = os.Stat(filepath.Join(dir, "repodata", "extra")); err != nil {
+		t.Error("the file written to the staging dir should be in the repodata")
+	}
+
+	// a failure leaves the previous repodata in place
+	repo.finishStaging = func(stagingDir string) error {
+		return os.ErrInvalid
+	}
+	if err = repo.genMetadata(context.Background(), empty()); err != os.ErrInvalid {
+		t.Errorf("genMetadata() should return the error of finishStaging, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "repodata", "extra")); err != nil {
+		t.Error("the previous repodata should be kept")
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	excludes := []string{"*-debuginfo-*.rpm", "old/*"}
+	cases := map[string]bool{
+		"/repo/foo-1.0-1.x86_64.rpm":               false,
+		"/repo/sub/foo-debuginfo-1.0-1.x86_64.rpm": true,
+		"/repo/old/foo-0.9-1.x86_64.rpm":           true,
+		"/repo/sub/old/foo-0.9-1.x86_64.rpm":       false,
+	}
+	for path, expected := range cases {
+		if isExcluded("/repo", path, excludes) != expected {
+			t.Errorf("isExcluded(%s) != %v", path, expected)
+		}
+	}
+}
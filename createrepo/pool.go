@@ -0,0 +1,359 @@
+package createrepo
+
+import "bufio"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "golang.org/x/net/context"
+
+// poolObjectName matches the file name of an object of a Pool
+var poolObjectName = regexp.MustCompile(`^[0-9a-f]{64}\.rpm$`)
+
+// poolSymlinks is the file of a Pool listing the symlinks made by Import,
+// one absolute path per line, which GC takes as references
+const poolSymlinks = "symlinks"
+
+// Pool stores RPMs once, keyed by their sha256, see OpenPool
+type Pool struct {
+	dir string
+}
+
+// OpenPool returns the Pool in dir, creating it if needed. The RPM with the
+// sha256 <hex> is stored as dir/objects/<first two hex digits>/<hex>.rpm.
+func OpenPool(dir string) (*Pool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+	return OpenExistingPool(dir)
+}
+
+// OpenExistingPool returns the Pool in dir like OpenPool, but fails if dir
+// is not a pool instead of creating one
+func OpenExistingPool(dir string) (*Pool, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(dir, "objects"))
+	switch {
+	case os.IsNotExist(err):
+		return nil, errors.New(fmt.Sprintf("%s is not a pool: it has no objects directory", dir))
+	case err != nil:
+		return nil, err
+	case !info.IsDir():
+		return nil, errors.New(fmt.Sprintf("%s is not a pool: objects is not a directory", dir))
+	}
+	// GC compares it to the targets of symlinks
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return nil, err
+	}
+	return &Pool{dir}, nil
+}
+
+// objectPath returns the path of the object of the given sha256
+func (p *Pool) objectPath(checksum string) string {
+	return filepath.Join(p.dir, "objects", checksum[:2], checksum+".rpm")
+}
+
+// PoolImportResult is the outcome of Pool.Import
+type PoolImportResult struct {
+	// Imported is the number of RPMs added to the pool
+	Imported int
+	// Deduplicated is the number of RPMs which were already in the pool,
+	// and Saved the bytes they no longer take
+	Deduplicated int
+	Saved        int64
+	Failures     []Failure
+}
+
+// Import adds the RPMs below dir to the pool and, unless link is "none",
+// replaces each of them by a "hard" link or a "symlink" to its object, so
+// that identical RPMs of several trees take the space of one. Hard links
+// require the pool and dir to be on the same file system.
+func (p *Pool) Import(ctx context.Context, dir string, link string) (*PoolImportResult, error) {
+	if link != "hard" && link != "symlink" && link != "none" {
+		return nil, errors.New(fmt.Sprintf("unknown link mode: %s", link))
+	}
+	repo := repository{baseDir: dir, followSymlinks: false}
+	files, errs := findRPMFiles(ctx, &repo)
+
+	result := PoolImportResult{}
+	done := make(chan struct{})
+	go func() {
+		for f := range errs {
+			result.Failures = append(result.Failures, Failure{f.path, f.err})
+		}
+		close(done)
+	}()
+
+	ts := newTS()
+	defer ts.close()
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	<-done
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return &result, ctx.Err()
+		}
+		// the header is parsed as well, so that only RPMs get in
+		info, err := ts.parsePackageInfo(path, "sha256")
+		if err == nil {
+			err = p.importFile(info, link, &result)
+		}
+		if err != nil {
+			result.Failures = append(result.Failures, Failure{path, err})
+		}
+	}
+	return &result, nil
+}
+
+// importFile stores the RPM of info as object and links it back
+func (p *Pool) importFile(info *packageInfo, link string, result *PoolImportResult) error {
+	object := p.objectPath(info.checksum)
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		return err
+	}
+
+	objectInfo, err := os.Stat(object)
+	switch {
+	case os.IsNotExist(err):
+		linked, err := p.store(info.path, object, link)
+		if err != nil {
+			return err
+		}
+		result.Imported++
+		if linked {
+			return nil
+		}
+	case err != nil:
+		return err
+	default:
+		fileInfo, err := os.Stat(info.path)
+		if err != nil {
+			return err
+		}
+		if os.SameFile(fileInfo, objectInfo) {
+			return nil
+		}
+		result.Deduplicated++
+		if link != "none" {
+			result.Saved += fileInfo.Size()
+		}
+	}
+
+	switch link {
+	case "hard":
+		return replaceFile(info.path, func(tmp string) error { return os.Link(object, tmp) })
+	case "symlink":
+		if err := replaceFile(info.path, func(tmp string) error { return os.Symlink(object, tmp) }); err != nil {
+			return err
+		}
+		return p.recordSymlink(info.path)
+	}
+	return nil
+}
+
+// recordSymlink adds the symlink at path to the symlinks of the pool
+func (p *Pool) recordSymlink(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(p.dir, poolSymlinks), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(file, path)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readSymlinks returns the symlinks recorded by Import, with the objects
+// they still point to. The other ones were removed or replaced since.
+func (p *Pool) readSymlinks() (map[string]string, error) {
+	file, err := os.Open(filepath.Join(p.dir, poolSymlinks))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	targets := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		path := scanner.Text()
+		target, err := filepath.EvalSymlinks(path)
+		if err == nil && filepath.Dir(filepath.Dir(target)) == filepath.Join(p.dir, "objects") {
+			targets[path] = target
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// writeSymlinks replaces the symlinks of the pool by those of targets
+func (p *Pool) writeSymlinks(targets map[string]string) error {
+	paths := make([]string, 0, len(targets))
+	for path := range targets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return replaceFile(filepath.Join(p.dir, poolSymlinks), func(tmp string) error {
+		content := ""
+		for _, path := range paths {
+			content += path + "\n"
+		}
+		return ioutil.WriteFile(tmp, []byte(content), 0644)
+	})
+}
+
+// store puts the file at path into the pool as object. If it is going to be
+// replaced by a hard link, it is hard linked if possible, which returns true,
+// and copied otherwise: the file kept by "none" must not share the object.
+func (p *Pool) store(path string, object string, link string) (bool, error) {
+	if link == "hard" && os.Link(path, object) == nil {
+		return true, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(object), ".import-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, os.Rename(tmp.Name(), object)
+}
+
+// replaceFile atomically replaces path by the file create makes at the
+// temporary path it is given
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".pool")
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// walkObjects calls fn for every file below the objects of the pool, with
+// ok telling whether it is named like an object
+func (p *Pool) walkObjects(fn func(path string, info os.FileInfo, ok bool) error) error {
+	root := filepath.Join(p.dir, "objects")
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+			return nil
+		}
+		name := info.Name()
+		ok := poolObjectName.MatchString(name) && filepath.Base(filepath.Dir(path)) == name[:2]
+		return fn(path, info, ok)
+	})
+}
+
+// Verify checks that every object of the pool has the sha256 it is named
+// after, and reports the files which are not objects
+func (p *Pool) Verify(ctx context.Context) (*VerifyReport, error) {
+	report := VerifyReport{Problems: []Problem{}}
+	err := p.walkObjects(func(path string, info os.FileInfo, ok bool) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !ok {
+			report.add(ProblemPoolStray, path, "not an object of the pool")
+			return nil
+		}
+		report.Packages++
+		checksum, _, err := checksumFile(path, "sha256")
+		switch {
+		case err != nil:
+			report.add(ProblemUnreadable, path, "%s", err.Error())
+		case checksum+".rpm" != info.Name():
+			report.add(ProblemPackageChecksum, path, "sha256 %s", checksum)
+		}
+		return nil
+	})
+	return &report, err
+}
+
+// GC removes the objects which are no longer referenced: neither hard
+// linked anywhere else nor the target of a symlink made by Import or found
+// below one of trees. The symlinks which no longer point into the pool are
+// forgotten. Nothing is removed if dryRun is set. It returns the objects
+// removed and the bytes they took.
+func (p *Pool) GC(ctx context.Context, trees []string, dryRun bool) ([]string, int64, error) {
+	targets, err := p.readSymlinks()
+	if err != nil {
+		return nil, 0, err
+	}
+	symlinked := make(map[string]bool)
+	for _, target := range targets {
+		symlinked[target] = true
+	}
+	for _, tree := range trees {
+		err := filepath.Walk(tree, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return err
+			}
+			if target, err := filepath.EvalSymlinks(path); err == nil {
+				symlinked[target] = true
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if targets != nil && !dryRun {
+		if err = p.writeSymlinks(targets); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var removed []string
+	var size int64
+	err = p.walkObjects(func(path string, info os.FileInfo, ok bool) error {
+		if !ok || hardLinks(info) > 1 || symlinked[path] {
+			return ctx.Err()
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		removed = append(removed, path)
+		size += info.Size()
+		return ctx.Err()
+	})
+	sort.Strings(removed)
+	return removed, size, err
+}
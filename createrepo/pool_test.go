@@ -0,0 +1,122 @@
+package createrepo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err = OpenExistingPool(dir); err == nil {
+		t.Error("OpenExistingPool() should fail on a directory without objects")
+	}
+	pool, err := OpenPool(filepath.Join(dir, "pool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenExistingPool(filepath.Join(dir, "pool")); err != nil {
+		t.Error("OpenExistingPool() failed:", err.Error())
+	}
+	hardTree := newTestRepo(t, "a.rpm")
+	defer os.RemoveAll(hardTree)
+	symlinkTree := newTestRepo(t, "b.rpm", "sub/c.rpm")
+	defer os.RemoveAll(symlinkTree)
+	ctx := context.Background()
+
+	result, err := pool.Import(ctx, hardTree, "hard")
+	if err != nil || len(result.Failures) != 0 || result.Imported != 1 {
+		t.Fatalf("Import() failed: %+v %v", result, err)
+	}
+	result, err = pool.Import(ctx, symlinkTree, "symlink")
+	if err != nil || len(result.Failures) != 0 || result.Imported != 0 || result.Deduplicated != 2 {
+		t.Fatalf("expected the RPMs to be deduplicated: %+v %v", result, err)
+	}
+	if result.Saved == 0 {
+		t.Error("deduplicating should save space")
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(symlinkTree, "sub", "c.rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, _, err := checksumReader(bytes.NewReader(content), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := pool.objectPath(checksum)
+	if target, err := os.Readlink(filepath.Join(symlinkTree, "sub", "c.rpm")); err != nil || target != object {
+		t.Errorf("c.rpm should link to %s, got %s", object, target)
+	}
+	info, err := os.Stat(filepath.Join(hardTree, "a.rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectInfo, err := os.Stat(object)
+	if err != nil || !os.SameFile(info, objectInfo) {
+		t.Error("a.rpm should be a hard link of the object")
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(filepath.Dir(object), "stray"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := pool.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Packages != 1 || len(report.Problems) != 1 || report.Problems[0].Kind != ProblemPoolStray {
+		t.Errorf("expected only the stray file, got %+v", report)
+	}
+
+	// the object is referenced by both trees, then only by the symlinks,
+	// which the pool knows about without being given their tree
+	removed, _, err := pool.GC(ctx, nil, true)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("the hard linked object should be kept, got %v %v", removed, err)
+	}
+	if err = os.Remove(filepath.Join(hardTree, "a.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	removed, _, err = pool.GC(ctx, []string{symlinkTree}, false)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("the symlinked object should be kept, got %v %v", removed, err)
+	}
+	removed, _, err = pool.GC(ctx, nil, false)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("the object symlinked by Import should be kept, got %v %v", removed, err)
+	}
+	// a symlink replaced by a file no longer counts
+	for _, name := range []string{"b.rpm", "sub/c.rpm"} {
+		if err = os.Remove(filepath.Join(symlinkTree, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(symlinkTree, "sub", "c.rpm"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, size, err := pool.GC(ctx, nil, false)
+	if err != nil || len(removed) != 1 || removed[0] != object || size != int64(len(content)) {
+		t.Errorf("expected the object to be removed, got %v %d %v", removed, size, err)
+	}
+
+	// the RPMs left alone do not share the inode of their object
+	noneTree := newTestRepo(t, "d.rpm")
+	defer os.RemoveAll(noneTree)
+	if result, err = pool.Import(ctx, noneTree, "none"); err != nil || result.Imported != 1 {
+		t.Fatalf("Import() failed: %+v %v", result, err)
+	}
+	if info, err = os.Stat(filepath.Join(noneTree, "d.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	if objectInfo, err = os.Stat(object); err != nil || os.SameFile(info, objectInfo) {
+		t.Error("d.rpm should not be a hard link of the object")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package createrepo
+
+import "os"
+import "syscall"
+
+// hardLinks returns the number of hard links of the file of info
+func hardLinks(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
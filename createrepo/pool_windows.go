@@ -0,0 +1,9 @@
+package createrepo
+
+import "os"
+
+// hardLinks returns the number of hard links of the file of info. It is
+// unknown here, so every object counts as referenced.
+func hardLinks(info os.FileInfo) uint64 {
+	return 2
+}
@@ -0,0 +1,250 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strings"
+import "time"
+
+// Repo is the repodata of a repository loaded for queries
+type Repo struct {
+	dir      string
+	packages []*Package
+}
+
+// Package is a package of a Repo
+type Package struct {
+	NEVRA
+	info *packageInfo
+	// LocationHref is the path of the package relative to the repository
+	LocationHref string
+}
+
+// ChangelogEntry is an entry of the changelog of a package
+type ChangelogEntry struct {
+	Author string    `json:"author"`
+	Date   time.Time `json:"date"`
+	Text   string    `json:"text"`
+}
+
+// Query selects packages of a Repo. All given criteria must match.
+type Query struct {
+	// Patterns are globs matched against the name, name.arch,
+	// name-version, name-version-release and the NEVRA, one of them has
+	// to match if there are any
+	Patterns []string
+	// WhatProvides is a capability or a file the packages provide
+	WhatProvides string
+	// WhatRequires is a capability or a file the packages require
+	WhatRequires string
+	// File is a path the packages contain
+	File string
+	// Latest keeps only the highest EVR of every name and arch
+	Latest bool
+}
+
+// OpenRepo reads the primary, filelists and other databases of the
+// repository in dir, whose packages are relative to dir.
+func OpenRepo(dir string) (*Repo, error) {
+	infos, err := loadPackages(dir, dir)
+	if err != nil {
+		return nil, err
+	}
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := Repo{dir: dir}
+	for _, info := range infos {
+		href, err := filepath.Rel(base, info.path)
+		if err != nil {
+			return nil, err
+		}
+		repo.packages = append(repo.packages, &Package{info.nevra(), info, filepath.ToSlash(href)})
+	}
+	sortByNEVRA(repo.packages)
+	return &repo, nil
+}
+
+// sortByNEVRA sorts packages by name, arch and EVR
+func sortByNEVRA(packages []*Package) {
+	sort.SliceStable(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+		switch {
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.Arch != b.Arch:
+			return a.Arch < b.Arch
+		}
+		return LabelCompare(a.NEVRA, b.NEVRA) < 0
+	})
+}
+
+// Packages returns all packages of the repository
+func (r *Repo) Packages() []*Package {
+	return append([]*Package{}, r.packages...)
+}
+
+// Query returns the packages matching q, sorted by name, arch and EVR
+func (r *Repo) Query(q Query) ([]*Package, error) {
+	for _, pattern := range q.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid pattern %q: %s", pattern, err.Error()))
+		}
+	}
+
+	var packages []*Package
+	for _, p := range r.packages {
+		switch {
+		case len(q.Patterns) != 0 && !p.matchesPattern(q.Patterns):
+		case q.WhatProvides != "" && !providesName(p.info.provides, q.WhatProvides) && !p.hasFile(q.WhatProvides):
+		case q.WhatRequires != "" && !providesName(p.info.requires, q.WhatRequires):
+		case q.File != "" && !p.hasFile(q.File):
+		default:
+			packages = append(packages, p)
+		}
+	}
+
+	if q.Latest {
+		packages = latestPackages(packages)
+	}
+	return packages, nil
+}
+
+func (p *Package) matchesPattern(patterns []string) bool {
+	names := []string{
+		p.Name,
+		p.Name + "." + p.Arch,
+		p.Name + "-" + p.Version,
+		p.Name + "-" + p.Version + "-" + p.Release,
+		p.String(),
+		p.Name + "-" + p.Version + "-" + p.Release + "." + p.Arch,
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// providesName returns true if one of deps has the given name
+func providesName(deps []dependency, name string) bool {
+	for _, d := range deps {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Package) hasFile(path string) bool {
+	for _, f := range p.info.files {
+		if f.name == path {
+			return true
+		}
+	}
+	return false
+}
+
+// latestPackages keeps the highest EVR of every name and arch of packages,
+// which are sorted by sortByNEVRA
+func latestPackages(packages []*Package) []*Package {
+	var latest []*Package
+	for i, p := range packages {
+		if i+1 < len(packages) && packages[i+1].Name == p.Name && packages[i+1].Arch == p.Arch {
+			continue
+		}
+		latest = append(latest, p)
+	}
+	return latest
+}
+
+// Changelog returns the changelog of the package, newest first
+func (p *Package) Changelog() []ChangelogEntry {
+	entries := make([]ChangelogEntry, len(p.info.changelogs))
+	for i, c := range p.info.changelogs {
+		entries[i] = ChangelogEntry{c.author, time.Unix(int64(c.date), 0).UTC(), c.text}
+	}
+	return entries
+}
+
+// Fields returns the fields of the package usable in a query format, keyed
+// by their column name in the primary database. Unset optional fields are
+// nil.
+func (p *Package) Fields() map[string]interface{} {
+	info := p.info
+	optional := func(s *string) interface{} {
+		if s == nil {
+			return nil
+		}
+		return *s
+	}
+	return map[string]interface{}{
+		"pkgId":            info.checksum,
+		"checksum_type":    info.checksumType,
+		"name":             info.rpmName,
+		"arch":             info.rpmArch,
+		"version":          info.rpmVersion,
+		"epoch":            info.rpmEpoch,
+		"release":          info.rpmRelease,
+		"nevra":            p.String(),
+		"evr":              p.EVR(),
+		"summary":          info.rpmSummary,
+		"description":      info.rpmDescription,
+		"url":              optional(info.rpmUrl),
+		"time_file":        info.fileTime,
+		"time_build":       info.rpmBuildTime,
+		"rpm_license":      optional(info.rpmLicense),
+		"rpm_vendor":       optional(info.rpmVendor),
+		"rpm_group":        optional(info.rpmGroup),
+		"rpm_buildhost":    optional(info.rpmBuildHost),
+		"rpm_sourcerpm":    optional(info.rpmSourceRpm),
+		"rpm_packager":     optional(info.rpmPackager),
+		"rpm_header_start": info.headerStart,
+		"rpm_header_end":   info.headerEnd,
+		"size_package":     info.fileSize,
+		"size_installed":   info.rpmInstallSize,
+		"size_archive":     info.rpmArchiveSize,
+		"location_href":    p.LocationHref,
+		"location_base":    optional(info.locationBase),
+	}
+}
+
+var queryFormatField = regexp.MustCompile(`%\{([A-Za-z_]+)\}`)
+
+// QueryFormat formats packages like rpm --queryformat: every %{field} is
+// replaced by a field of Package.Fields, and \n and \t are unescaped.
+type QueryFormat struct {
+	format string
+}
+
+// ParseQueryFormat checks that every field in format exists
+func ParseQueryFormat(format string) (*QueryFormat, error) {
+	fields := (&Package{info: &packageInfo{}}).Fields()
+	for _, match := range queryFormatField.FindAllStringSubmatch(format, -1) {
+		if _, ok := fields[match[1]]; !ok {
+			return nil, errors.New(fmt.Sprintf("unknown field in query format: %s", match[0]))
+		}
+	}
+
+	format = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(format)
+	return &QueryFormat{format}, nil
+}
+
+// Format returns the formatted fields of p, unset fields are "(none)"
+func (f *QueryFormat) Format(p *Package) string {
+	fields := p.Fields()
+	return queryFormatField.ReplaceAllStringFunc(f.format, func(match string) string {
+		value := fields[match[2:len(match)-1]]
+		if value == nil {
+			return "(none)"
+		}
+		return fmt.Sprint(value)
+	})
+}
@@ -0,0 +1,67 @@
+package createrepo
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestQuery(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm", "sub/copy.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	repo, err := OpenRepo(dir)
+	if err != nil {
+		t.Fatal("OpenRepo() failed:", err.Error())
+	}
+
+	tests := []struct {
+		query    Query
+		expected int
+	}{
+		{Query{}, 2},
+		{Query{Patterns: []string{"openssl"}}, 2},
+		{Query{Patterns: []string{"openssl-1.0.1e-30.el6_6.5.x86_64"}}, 2},
+		{Query{Patterns: []string{"open*.i686", "nss*"}}, 0},
+		{Query{WhatProvides: "libssl.so.10()(64bit)"}, 2},
+		{Query{WhatProvides: "/etc/pki/tls/openssl.cnf"}, 2},
+		{Query{WhatProvides: "libssl.so.11"}, 0},
+		{Query{WhatRequires: "/bin/sh"}, 2},
+		{Query{File: "/usr/lib64/libssl.so.10"}, 2},
+		{Query{Latest: true}, 1},
+	}
+	for _, test := range tests {
+		packages, err := repo.Query(test.query)
+		if err != nil {
+			t.Errorf("Query(%+v) failed: %s", test.query, err.Error())
+		} else if len(packages) != test.expected {
+			t.Errorf("Query(%+v) returned %d packages, expected %d", test.query, len(packages), test.expected)
+		}
+	}
+
+	packages, _ := repo.Query(Query{})
+	shouldEqualStr(t, "location_href", packages[1].LocationHref, "sub/copy.rpm")
+
+	qf, err := ParseQueryFormat(`%{name}-%{evr}\t%{size_package} %{rpm_vendor}\n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shouldEqualStr(t, "query format", qf.Format(packages[0]), "openssl-0:1.0.1e-30.el6_6.5\t1589496 CentOS\n")
+	if _, err = ParseQueryFormat("%{name} %{nope}"); err == nil || !strings.Contains(err.Error(), "%{nope}") {
+		t.Error("unknown fields should be rejected")
+	}
+
+	if len(packages[0].Changelog()) != 303 {
+		t.Errorf("expected 303 changelog entries, got %d", len(packages[0].Changelog()))
+	}
+}
@@ -0,0 +1,353 @@
+package createrepo
+
+import "bufio"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "path"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+import "golang.org/x/net/context"
+
+// firstRangeSize is the size of the first Range request for a RPM, which
+// usually covers the lead, the signature and the header. Every further
+// request doubles it.
+const firstRangeSize = 64 << 10
+
+// checksumLengths maps the length of a hex digest to its checksum type
+var checksumLengths = map[int]string{
+	32:  "md5",
+	40:  "sha1",
+	56:  "sha224",
+	64:  "sha256",
+	96:  "sha384",
+	128: "sha512",
+}
+
+// ReadChecksums reads a checksum list as written by sha256sum and friends,
+// "<hex digest>  <path>" per line, and returns the digests by path. The
+// type of each digest is told by its length.
+func ReadChecksums(r io.Reader) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || checksumLengths[len(fields[0])] == "" {
+			return nil, errors.New(fmt.Sprintf("line %d: not a checksum list entry: %s", n, line))
+		}
+		// "*" marks files checksummed in binary mode
+		name := strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+		checksums[strings.TrimPrefix(name, "./")] = strings.ToLower(fields[0])
+	}
+	return checksums, scanner.Err()
+}
+
+// RemoteOptions configure IndexRemote
+type RemoteOptions struct {
+	// Packages are the locations of the RPMs relative to the base URL. All
+	// the RPMs of Checksums are indexed if it is empty.
+	Packages []string
+	// Checksums are the digests of the RPMs by location, which become their
+	// pkgId, see ReadChecksums
+	Checksums map[string]string
+	// HeaderDigest allows RPMs missing from Checksums, which get the SHA256
+	// or SHA1 digest of their header as pkgId. It identifies the package but
+	// is not a checksum of the file, so clients verifying the downloads
+	// reject these packages.
+	HeaderDigest bool
+	// Workers is the number of RPMs fetched concurrently, 1 by default
+	Workers int
+	// ChecksumType is the checksum of the metadata, sha256 by default
+	ChecksumType string
+	// Compression is the compression of the metadata, gz by default
+	Compression string
+	// Client does the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// RemoteResult is the outcome of IndexRemote
+type RemoteResult struct {
+	// Indexed is the number of packages in the repodata
+	Indexed  int
+	Failures []Failure
+	// Fetched is the number of bytes downloaded
+	Fetched int64
+}
+
+// WriteSummary prints the failed packages with their reason to w
+func (r *RemoteResult) WriteSummary(w io.Writer) {
+	(&Result{Failures: r.Failures}).WriteSummary(w)
+}
+
+// IndexRemote writes into outputDir/repodata the packages found at baseURL,
+// fetching only the lead, the signature and the header of each RPM with
+// HTTP Range requests. The packages keep their location at baseURL, which
+// becomes their location_base. Packages which cannot be indexed are
+// reported in the result and left out.
+func IndexRemote(ctx context.Context, baseURL string, outputDir string, options RemoteOptions) (*RemoteResult, error) {
+	if options.ChecksumType == "" {
+		options.ChecksumType = "sha256"
+	}
+	if _, err := newHash(options.ChecksumType); err != nil {
+		return nil, err
+	}
+	if options.Compression == "" {
+		options.Compression = "gz"
+	}
+	if _, err := getCompressor(options.Compression); err != nil {
+		return nil, err
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, errors.New(fmt.Sprintf("not a HTTP URL: %s", baseURL))
+	}
+
+	hrefs := options.Packages
+	if len(hrefs) == 0 {
+		for href := range options.Checksums {
+			if looksLikeRPM(href) {
+				hrefs = append(hrefs, href)
+			}
+		}
+		sort.Strings(hrefs)
+	}
+	if len(hrefs) == 0 {
+		return nil, errors.New("no package to index")
+	}
+
+	result := RemoteResult{}
+	infos := make([]*packageInfo, len(hrefs))
+	failures := make([]error, len(hrefs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+	wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go func() {
+			ts := newTS()
+			defer ts.close()
+			defer wg.Done()
+			for i := range next {
+				info, fetched, err := ts.fetchPackageInfo(ctx, options, base, hrefs[i])
+				infos[i], failures[i] = info, err
+				mu.Lock()
+				result.Fetched += fetched
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range hrefs {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, errors.New(fmt.Sprintf("indexing %s canceled: %s", baseURL, ctx.Err().Error()))
+	}
+
+	location := base.String()
+	c := make(chan *packageInfo)
+	go func() {
+		defer close(c)
+		for i, info := range infos {
+			if failures[i] != nil {
+				result.Failures = append(result.Failures, Failure{hrefs[i], failures[i]})
+				continue
+			}
+			info.locationBase = &location
+			result.Indexed++
+			c <- info
+		}
+	}()
+
+	repo := repository{
+		baseDir:      outputDir,
+		outputDir:    outputDir,
+		checksumType: options.ChecksumType,
+		compression:  options.Compression,
+	}
+	if err = repo.genMetadata(ctx, c); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// fetchPackageInfo reads the packageInfo of the RPM at href below base. It
+// also returns the number of bytes downloaded.
+func (ts rpmts) fetchPackageInfo(ctx context.Context, options RemoteOptions, base *url.URL, href string) (*packageInfo, int64, error) {
+	rel, err := url.Parse(href)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rel.IsAbs() || rel.Host != "" || strings.HasPrefix(rel.Path, "/") || strings.HasPrefix(path.Clean(rel.Path), "..") {
+		return nil, 0, errors.New("not a location below the base URL")
+	}
+	location := base.ResolveReference(rel).String()
+
+	r := &rangeReader{ctx: ctx, client: options.Client, url: location, size: -1, next: firstRangeSize}
+	defer r.close()
+	hdr, err := ts.readRPM(location, r)
+	if err != nil {
+		return nil, r.fetched, err
+	}
+	defer hdr.close()
+
+	info := packageInfo{path: location, locationHref: path.Clean(rel.Path)}
+	if err = hdr.readTags(&info); err != nil {
+		return nil, r.fetched, err
+	}
+	if r.size < 0 {
+		return nil, r.fetched, errors.New("the server did not tell the size of the file")
+	}
+	info.fileSize = uint64(r.size)
+	// without Last-Modified, the build time keeps the repodata reproducible
+	info.fileTime = info.rpmBuildTime
+	if !r.modTime.IsZero() {
+		info.fileTime = uint32(r.modTime.Unix())
+	}
+
+	if checksum, ok := options.Checksums[info.locationHref]; ok {
+		info.checksum = checksum
+		info.checksumType = checksumLengths[len(checksum)]
+		return &info, r.fetched, nil
+	}
+	if !options.HeaderDigest {
+		return nil, r.fetched, errors.New("missing from the checksum list")
+	}
+	for _, digest := range []struct{ tag, checksumType string }{{"sha256header", "sha256"}, {"sha1header", "sha1"}} {
+		if info.checksum, err = hdr.getString(digest.tag); err == nil {
+			info.checksumType = digest.checksumType
+			return &info, r.fetched, nil
+		}
+	}
+	return nil, r.fetched, errors.New("missing from the checksum list and without header digest")
+}
+
+// rangeReader reads a remote file from its start with Range requests of
+// growing size, so that only about the bytes read are downloaded. If the
+// server ignores the Range, the whole response is read as needed instead.
+type rangeReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	// offset is where the next request starts, next its size
+	offset int64
+	next   int64
+	// buf holds the bytes of the last range which are not read yet
+	buf []byte
+	// body is the response of a server ignoring the Range
+	body io.ReadCloser
+	// size and modTime describe the file once the first response is in,
+	// size is -1 and modTime zero if the server did not tell
+	size    int64
+	modTime time.Time
+	fetched int64
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 && r.body == nil {
+		if r.size >= 0 && r.offset >= r.size {
+			return 0, io.EOF
+		}
+		if err := r.fetch(); err != nil {
+			return 0, err
+		}
+	}
+	if r.body != nil {
+		n, err := r.body.Read(p)
+		r.fetched += int64(n)
+		return n, err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fetch requests the next range into buf
+func (r *rangeReader) fetch() error {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(r.ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+r.next-1))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if r.offset == 0 {
+		if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			r.modTime = t
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		defer resp.Body.Close()
+		r.size = contentRangeSize(resp.Header.Get("Content-Range"))
+		r.buf, err = ioutil.ReadAll(io.LimitReader(resp.Body, r.next))
+		r.fetched += int64(len(r.buf))
+		r.offset += int64(len(r.buf))
+		r.next *= 2
+		if err == nil && len(r.buf) == 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	case http.StatusOK:
+		if r.offset != 0 {
+			resp.Body.Close()
+			return errors.New("the server stopped honoring Range requests")
+		}
+		r.size = resp.ContentLength
+		r.body = resp.Body
+		return nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return io.EOF
+	}
+	resp.Body.Close()
+	return errors.New(fmt.Sprintf("GET %s: %s", r.url, resp.Status))
+}
+
+// close ends the download of a server ignoring the Range
+func (r *rangeReader) close() {
+	if r.body != nil {
+		r.body.Close()
+	}
+}
+
+// contentRangeSize returns the complete length of a Content-Range, -1 if it
+// is unknown
+func contentRangeSize(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,111 @@
+package createrepo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestReadChecksums(t *testing.T) {
+	list := "# generated\n" + strings.Repeat("a", 64) + "  ./os/a.rpm\n" + strings.Repeat("B", 40) + " *b.rpm\n\n"
+	checksums, err := ReadChecksums(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shouldEqualStr(t, "a.rpm", checksums["os/a.rpm"], strings.Repeat("a", 64))
+	shouldEqualStr(t, "b.rpm", checksums["b.rpm"], strings.Repeat("b", 40))
+
+	if _, err = ReadChecksums(strings.NewReader("abc  c.rpm\n")); err == nil {
+		t.Error("a digest of unknown length should be rejected")
+	}
+}
+
+func TestIndexRemote(t *testing.T) {
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(1500000000, 0)
+	ranges := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/os/openssl.rpm" && r.URL.Path != "/os/other.rpm" {
+			http.NotFound(w, r)
+			return
+		}
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "openssl.rpm", modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	checksum := fmt.Sprintf("%x", sha256.Sum256(content))
+	index := func(options RemoteOptions) (*RemoteResult, []*packageInfo) {
+		dir, err := ioutil.TempDir("", "remote")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		result, err := IndexRemote(context.Background(), ts.URL+"/os", dir, options)
+		if err != nil {
+			t.Fatal("IndexRemote() failed:", err.Error())
+		}
+		packages, err := loadPackages(dir, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result, packages
+	}
+
+	result, packages := index(RemoteOptions{Checksums: map[string]string{"openssl.rpm": checksum}})
+	if result.Indexed != 1 || len(packages) != 1 {
+		t.Fatalf("expected one package, got %+v", result)
+	}
+	if result.Fetched >= int64(len(content)) {
+		t.Errorf("fetched %d bytes of a %d bytes RPM", result.Fetched, len(content))
+	}
+	p := packages[0]
+	shouldEqualStr(t, "pkgId", p.checksum, checksum)
+	shouldEqualStr(t, "location", p.location(), ts.URL+"/os/openssl.rpm")
+	shouldEqualStr(t, "name", p.rpmName, "openssl")
+	shouldEqualU64(t, "size", p.fileSize, uint64(len(content)))
+	shouldEqualU32(t, "time", p.fileTime, uint32(modTime.Unix()))
+
+	// the local parser must agree on everything taken from the header
+	tsRPM := newTS()
+	defer tsRPM.close()
+	local, err := tsRPM.parsePackageInfo("openssl.rpm", "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shouldEqualU64(t, "header end", p.headerEnd, local.headerEnd)
+	if len(p.files) != len(local.files) || len(p.requires) != len(local.requires) {
+		t.Error("the remote package differs from the local one")
+	}
+
+	// without the Range support of the server, the body is read as needed,
+	// and without Last-Modified the build time is the file time
+	ranges, modTime = false, time.Time{}
+	result, packages = index(RemoteOptions{Packages: []string{"other.rpm", "missing.rpm"}, HeaderDigest: true})
+	if result.Indexed != 1 || len(result.Failures) != 1 || result.Failures[0].Path != "missing.rpm" {
+		t.Fatalf("expected missing.rpm to fail, got %+v", result)
+	}
+	shouldEqualStr(t, "header digest type", packages[0].checksumType, "sha1")
+	shouldEqualU32(t, "time without Last-Modified", packages[0].fileTime, local.rpmBuildTime)
+	ranges = true
+
+	result, _ = index(RemoteOptions{Packages: []string{"openssl.rpm"}})
+	if len(result.Failures) != 1 {
+		t.Error("a package without checksum should fail unless HeaderDigest is set")
+	}
+}
@@ -0,0 +1,577 @@
+package createrepo
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+)
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// SQL for initializing databases are copied from createrepo/__init__.py
+const (
+	repoDBVersion    int    = 10
+	sqlInitPrimaryDB string = `
+        PRAGMA synchronous="OFF";
+        pragma locking_mode="EXCLUSIVE";
+        CREATE TABLE conflicts (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER );
+        CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+        CREATE TABLE files (  name TEXT,  type TEXT,  pkgKey INTEGER);
+        CREATE TABLE obsoletes (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER );
+        CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT,  name TEXT,  arch TEXT,  version TEXT,  epoch TEXT,  release TEXT,  summary TEXT,  description TEXT,  url TEXT,  time_file INTEGER,  time_build INTEGER,  rpm_license TEXT,  rpm_vendor TEXT,  rpm_group TEXT,  rpm_buildhost TEXT,  rpm_sourcerpm TEXT,  rpm_header_start INTEGER,  rpm_header_end INTEGER,  rpm_packager TEXT,  size_package INTEGER,  size_installed INTEGER,  size_archive INTEGER,  location_href TEXT,  location_base TEXT,  checksum_type TEXT);
+        CREATE TABLE provides (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER );
+        CREATE TABLE requires (  name TEXT,  flags TEXT,  epoch TEXT,  version TEXT,  release TEXT,  pkgKey INTEGER , pre BOOL DEFAULT FALSE);
+        CREATE INDEX filenames ON files (name);
+        CREATE INDEX packageId ON packages (pkgId);
+        CREATE INDEX packagename ON packages (name);
+        CREATE INDEX pkgconflicts on conflicts (pkgKey);
+        CREATE INDEX pkgobsoletes on obsoletes (pkgKey);
+        CREATE INDEX pkgprovides on provides (pkgKey);
+        CREATE INDEX pkgrequires on requires (pkgKey);
+        CREATE INDEX providesname ON provides (name);
+        CREATE INDEX requiresname ON requires (name);
+        CREATE TRIGGER removals AFTER DELETE ON packages
+             BEGIN
+             DELETE FROM files WHERE pkgKey = old.pkgKey;
+             DELETE FROM requires WHERE pkgKey = old.pkgKey;
+             DELETE FROM provides WHERE pkgKey = old.pkgKey;
+             DELETE FROM conflicts WHERE pkgKey = old.pkgKey;
+             DELETE FROM obsoletes WHERE pkgKey = old.pkgKey;
+             END;
+`
+	sqlInitFilelistsDB string = `
+            PRAGMA synchronous="OFF";
+            pragma locking_mode="EXCLUSIVE";
+            CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+            CREATE TABLE filelist (  pkgKey INTEGER,  dirname TEXT,  filenames TEXT,  filetypes TEXT);
+            CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT);
+            CREATE INDEX dirnames ON filelist (dirname);
+            CREATE INDEX keyfile ON filelist (pkgKey);
+            CREATE INDEX pkgId ON packages (pkgId);
+            CREATE TRIGGER remove_filelist AFTER DELETE ON packages
+                   BEGIN
+                   DELETE FROM filelist WHERE pkgKey = old.pkgKey;
+                   END;
+`
+	sqlInitOtherDB string = `
+            PRAGMA synchronous="OFF";
+            pragma locking_mode="EXCLUSIVE";
+            CREATE TABLE changelog (  pkgKey INTEGER,  author TEXT,  date INTEGER,  changelog TEXT);
+            CREATE TABLE db_info (dbversion INTEGER, checksum TEXT);
+            CREATE TABLE packages (  pkgKey INTEGER PRIMARY KEY,  pkgId TEXT);
+            CREATE INDEX keychange ON changelog (pkgKey);
+            CREATE INDEX pkgId ON packages (pkgId);
+            CREATE TRIGGER remove_changelogs AFTER DELETE ON packages
+                 BEGIN
+                 DELETE FROM changelog WHERE pkgKey = old.pkgKey;
+                 END;
+`
+)
+
+func initDB(db *sql.DB, sqlCreateTables string) error {
+	_, err := db.Exec(sqlCreateTables)
+	return err
+}
+
+// finishDB records checksum in db_info and rewrites the database with
+// VACUUM, so that the same content always gives the same file
+func finishDB(db *sql.DB, checksum string) error {
+	if _, err := db.Exec("INSERT into db_info values (?, ?);", repoDBVersion, checksum); err != nil {
+		return err
+	}
+	_, err := db.Exec("VACUUM;")
+	return err
+}
+
+// contentChecksum returns the checksum of the pkgIds of packages, which are
+// in the order of the database. It is stored in db_info instead of the
+// checksum of the XML metadata createrepo writes there, as there is none.
+func contentChecksum(packages []*packageInfo, checksumType string) (string, error) {
+	hash, err := newHash(checksumType)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range packages {
+		io.WriteString(hash, p.checksum+"\n")
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func initPrimaryDB(db *sql.DB) error {
+	return initDB(db, sqlInitPrimaryDB)
+}
+
+func initFilelistsDB(db *sql.DB) error {
+	return initDB(db, sqlInitFilelistsDB)
+}
+
+func initOtherDB(db *sql.DB) error {
+	return initDB(db, sqlInitOtherDB)
+}
+
+// checksumTypes are the checksum types usable for packages and metadata
+var checksumTypes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// normalizeChecksumType returns the name of checksumTypes of a checksum type
+// found in existing repodata: yum called sha1 "sha"
+func normalizeChecksumType(checksumType string) string {
+	if checksumType == "sha" {
+		return "sha1"
+	}
+	return checksumType
+}
+
+// newHash returns a hash.Hash for the given checksum type
+func newHash(checksumType string) (hash.Hash, error) {
+	newFunc, ok := checksumTypes[checksumType]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported checksum type: %s", checksumType))
+	}
+	return newFunc(), nil
+}
+
+type repository struct {
+	// baseDir is the directory containing the RPMs, location_href is
+	// relative to it
+	baseDir string
+	// outputDir is where repodata/ is written, baseDir unless overridden
+	outputDir string
+	// workers is the number of RPMs parsed concurrently
+	workers int
+	// checksumType is used for pkgId as well as the metadata files
+	checksumType string
+	// compression is the compression of the metadata files, see compressors
+	compression string
+	// excludes are glob patterns of paths which are not indexed
+	excludes []string
+	// pkglist, if not nil, are the paths of the RPMs to index instead of
+	// every RPM found in baseDir
+	pkglist []string
+	// followSymlinks makes symlinks to RPMs and directories count as the
+	// real thing, they are skipped otherwise
+	followSymlinks bool
+	// srpmsDir and debugDir, if set, get the repodata of the source and the
+	// debuginfo/debugsource packages respectively instead of outputDir
+	srpmsDir string
+	debugDir string
+	// arches, if not empty, replaces the repodata in outputDir by one
+	// repository per target architecture, see archDir and compatible
+	arches []string
+	// archLayout is the path of the repository of an architecture relative
+	// to outputDir, with {arch} standing for the architecture
+	archLayout string
+	// compatArches overrides defaultCompatArches for the targets it lists
+	compatArches map[string][]string
+	// finishStaging, if set, is called by genMetadata with every complete
+	// staging repodata directory before it replaces the repodata
+	finishStaging func(stagingDir string) error
+}
+
+// outputDirs returns the directories which get a repodata tree
+func (repo *repository) outputDirs() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	candidates := []string{repo.outputDir}
+	if len(repo.arches) != 0 {
+		candidates = nil
+		for _, arch := range repo.arches {
+			candidates = append(candidates, repo.archDir(arch))
+		}
+	}
+	for _, dir := range append(candidates, repo.srpmsDir, repo.debugDir) {
+		if dir != "" && !seen[filepath.Clean(dir)] {
+			seen[filepath.Clean(dir)] = true
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+// route returns the output directories whose repodata lists p. It is empty
+// if p does not match any of the target architectures.
+func (repo *repository) route(p *packageInfo) []string {
+	switch {
+	case repo.srpmsDir != "" && p.isSource():
+		return []string{filepath.Clean(repo.srpmsDir)}
+	case repo.debugDir != "" && p.isDebug():
+		return []string{filepath.Clean(repo.debugDir)}
+	case len(repo.arches) != 0:
+		return repo.archDirs(p)
+	}
+	return []string{filepath.Clean(repo.outputDir)}
+}
+
+// locationHref returns the path of the package relative to the base directory
+func (repo *repository) locationHref(p *packageInfo) (string, error) {
+	// packages of another repository keep their location there
+	if p.locationBase != nil {
+		return p.locationHref, nil
+	}
+
+	baseDir, err := filepath.Abs(repo.baseDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(baseDir, p.path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// packageInfo hold the necessary information for a RPM package to create metadata database
+type packageInfo struct {
+	// path is the absolute path to the RPM
+	path string
+	// checksum is the checksum of the RPM package
+	checksum string
+	// checksumType is the type of checksum used to checksum the RPM package
+	checksumType string
+	fileTime     uint32
+	fileSize     uint64
+	headerStart  uint64
+	headerEnd    uint64
+
+	rpmName    string
+	rpmArch    string
+	rpmVersion string
+	rpmEpoch   string
+	rpmRelease string
+	// rpmSummary is %{summary} with leading and trailing spaces stripped
+	rpmSummary string
+	// rpmDescription is %{description} with leading and trailing spaces stripped
+	rpmDescription string
+	// rpmUrl is the URL of the project if any
+	rpmUrl       *string
+	rpmBuildTime uint32
+	rpmLicense   *string
+	rpmVendor    *string
+	rpmGroup     *string
+	rpmBuildHost *string
+	rpmSourceRpm *string
+	rpmPackager  *string
+	// rpmInstallSize is %{size}
+	rpmInstallSize uint64
+	// rpmArchiveSize is %{archivesize}
+	rpmArchiveSize uint64
+
+	provides   []dependency
+	requires   []dependency
+	conflicts  []dependency
+	obsoletes  []dependency
+	files      []packageFile
+	changelogs []changelog
+
+	// pkgKey is the row of the package in the databases it was read from
+	pkgKey int64
+	// locationBase is the URL of the repository the package is fetched
+	// from, nil if it is in the repository itself
+	locationBase *string
+	// locationHref is the location_href the package was read with
+	locationHref string
+}
+
+// isSource returns true for source packages
+func (p *packageInfo) isSource() bool {
+	return p.rpmArch == "src" || p.rpmArch == "nosrc"
+}
+
+// isDebug returns true for debuginfo and debugsource packages
+func (p *packageInfo) isDebug() bool {
+	return strings.Contains(p.rpmName, "-debuginfo") || strings.HasSuffix(p.rpmName, "-debugsource")
+}
+
+// parsePackageInfo reads the RPM at path and returns its packageInfo, with
+// the file checksummed by checksumType.
+func (ts rpmts) parsePackageInfo(path string, checksumType string) (*packageInfo, error) {
+	var info packageInfo
+	var err error
+
+	info.path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is read only once: the header is decoded while the bytes go
+	// through the hash, and the rest of the payload is hashed afterwards.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info.fileTime = uint32(fileInfo.ModTime().Unix())
+	info.fileSize = uint64(fileInfo.Size())
+
+	hash, err := newHash(checksumType)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := ts.readRPM(path, io.TeeReader(file, hash))
+	if err != nil {
+		return nil, err
+	}
+	defer hdr.close()
+
+	if _, err = io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	info.checksumType = checksumType
+	info.checksum = fmt.Sprintf("%x", hash.Sum(nil))
+
+	if err = hdr.readTags(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// readTags sets the fields of info which come from the header, everything
+// but the path and the checksum, size and time of the file
+func (hdr *rpmheader) readTags(info *packageInfo) error {
+	var err error
+
+	info.headerStart, info.headerEnd, err = hdr.getHeaderRange()
+	if err != nil {
+		return err
+	}
+
+	info.rpmName, err = hdr.getString("name")
+	if err != nil {
+		return err
+	}
+
+	info.rpmArch, err = hdr.getString("arch")
+	if err != nil {
+		return err
+	}
+	// %{arch} of a source package is the architecture it was built on,
+	// createrepo records it as src, or nosrc if sources are left out
+	if !hdr.hasTag("sourcerpm") {
+		info.rpmArch = "src"
+		if hdr.hasTag("nosource") || hdr.hasTag("nopatch") {
+			info.rpmArch = "nosrc"
+		}
+	}
+
+	info.rpmVersion, err = hdr.getString("version")
+	if err != nil {
+		return err
+	}
+
+	info.rpmEpoch, err = hdr.getString("epoch")
+	if err != nil {
+		// RPM spec states that we could omit Epoch, but createrepo
+		// actually use "0" as the default value if Epoch is not present
+		// in a RPM.
+		info.rpmEpoch = "0"
+	}
+
+	info.rpmRelease, err = hdr.getString("release")
+	if err != nil {
+		return err
+	}
+
+	info.rpmSummary, err = hdr.getString("summary")
+	if err != nil {
+		return err
+	}
+
+	info.rpmDescription, err = hdr.getString("description")
+	if err != nil {
+		return err
+	}
+
+	rpmUrl, err := hdr.getString("url")
+	if err == nil {
+		info.rpmUrl = &rpmUrl
+	}
+
+	rpmBuildTime, err := hdr.getNumber("buildtime")
+	if err != nil {
+		return err
+	}
+	info.rpmBuildTime = uint32(rpmBuildTime)
+
+	rpmLicense, err := hdr.getString("license")
+	if err == nil {
+		info.rpmLicense = &rpmLicense
+	}
+
+	rpmVendor, err := hdr.getString("vendor")
+	if err == nil {
+		info.rpmVendor = &rpmVendor
+	}
+
+	rpmGroup, err := hdr.getString("group")
+	if err == nil {
+		info.rpmGroup = &rpmGroup
+	}
+
+	rpmBuildHost, err := hdr.getString("buildhost")
+	if err == nil {
+		info.rpmBuildHost = &rpmBuildHost
+	}
+	rpmSourceRpm, err := hdr.getString("sourcerpm")
+	if err == nil {
+		info.rpmSourceRpm = &rpmSourceRpm
+	}
+	rpmPackager, err := hdr.getString("packager")
+	if err == nil {
+		info.rpmPackager = &rpmPackager
+	}
+
+	info.rpmInstallSize, err = hdr.getNumber("size")
+	if err != nil {
+		return err
+	}
+
+	info.rpmArchiveSize, err = hdr.getNumber("archivesize")
+	if err != nil {
+		return err
+	}
+
+	deps := map[string]*[]dependency{
+		"provide":  &info.provides,
+		"require":  &info.requires,
+		"conflict": &info.conflicts,
+		"obsolete": &info.obsoletes,
+	}
+	for kind, dst := range deps {
+		if *dst, err = hdr.readDependencies(kind); err != nil {
+			return err
+		}
+	}
+
+	info.files, err = hdr.readFiles()
+	if err != nil {
+		return err
+	}
+
+	info.changelogs, err = hdr.readChangelogs()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// primaryColumns are the columns of the packages table in the primary
+// database, in the order of packageInfo.primaryValues()
+const primaryColumns = "pkgId, name, arch, version, epoch, release, summary, description, url, time_file, time_build, rpm_license, rpm_vendor, rpm_group, rpm_buildhost, rpm_sourcerpm, rpm_header_start, rpm_header_end, rpm_packager, size_package, size_installed, size_archive, location_href, location_base, checksum_type"
+
+// primaryValues returns the values of a row in the packages table of the
+// primary database
+func (p *packageInfo) primaryValues(locationHref string) []interface{} {
+	return []interface{}{
+		p.checksum,
+		p.rpmName,
+		p.rpmArch,
+		p.rpmVersion,
+		p.rpmEpoch,
+		p.rpmRelease,
+		p.rpmSummary,
+		p.rpmDescription,
+		p.rpmUrl,
+		p.fileTime,
+		p.rpmBuildTime,
+		p.rpmLicense,
+		p.rpmVendor,
+		p.rpmGroup,
+		p.rpmBuildHost,
+		p.rpmSourceRpm,
+		p.headerStart,
+		p.headerEnd,
+		p.rpmPackager,
+		p.fileSize,
+		p.rpmInstallSize,
+		p.rpmArchiveSize,
+		locationHref,
+		p.locationBase,
+		p.checksumType,
+	}
+}
+
+// readPackages loads the packages table of a primary database. The path of
+// each package is resolved against baseDir.
+func readPackages(db *sql.DB, baseDir string) ([]*packageInfo, error) {
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT pkgKey, " + primaryColumns + " FROM packages ORDER BY pkgKey")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var packages []*packageInfo
+	for rows.Next() {
+		var p packageInfo
+		// other tools may leave these NULL
+		var epoch, summary, description sql.NullString
+		err = rows.Scan(
+			&p.pkgKey,
+			&p.checksum,
+			&p.rpmName,
+			&p.rpmArch,
+			&p.rpmVersion,
+			&epoch,
+			&p.rpmRelease,
+			&summary,
+			&description,
+			&p.rpmUrl,
+			&p.fileTime,
+			&p.rpmBuildTime,
+			&p.rpmLicense,
+			&p.rpmVendor,
+			&p.rpmGroup,
+			&p.rpmBuildHost,
+			&p.rpmSourceRpm,
+			&p.headerStart,
+			&p.headerEnd,
+			&p.rpmPackager,
+			&p.fileSize,
+			&p.rpmInstallSize,
+			&p.rpmArchiveSize,
+			&p.locationHref,
+			&p.locationBase,
+			&p.checksumType,
+		)
+		if err != nil {
+			return nil, err
+		}
+		p.rpmEpoch, p.rpmSummary, p.rpmDescription = epoch.String, summary.String, description.String
+		p.checksumType = normalizeChecksumType(p.checksumType)
+		if p.rpmEpoch == "" {
+			p.rpmEpoch = "0"
+		}
+		p.path = filepath.Join(baseDir, filepath.FromSlash(p.locationHref))
+		packages = append(packages, &p)
+	}
+
+	return packages, rows.Err()
+}
@@ -1,7 +1,9 @@
-package main
+package createrepo
 
 import (
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -35,7 +37,7 @@ func TestPackageInfo(t *testing.T) {
 	ts := newTS()
 	defer ts.close()
 
-	info, err := ts.parsePackageInfo("openssl.rpm")
+	info, err := ts.parsePackageInfo("openssl.rpm", "sha256")
 	if err != nil {
 		t.Fatal("parsePackageInfo(openssl.rpm) failed:", err.Error())
 	}
@@ -68,3 +70,94 @@ protocols.`)
 	shouldEqualU64(t, "info.rpmInstallSize", info.rpmInstallSize, 4222195)
 	shouldEqualU64(t, "info.rpmArchiveSize", info.rpmArchiveSize, 4238188)
 }
+
+// BenchmarkParsePackageInfo measures the throughput of parsePackageInfo over
+// all RPMs in the directory given by $BENCH_RPM_DIR (default: current directory).
+func BenchmarkParsePackageInfo(b *testing.B) {
+	dir := os.Getenv("BENCH_RPM_DIR")
+	if dir == "" {
+		dir = "."
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rpm"))
+	if err != nil || len(paths) == 0 {
+		b.Skip("no RPM found in", dir)
+	}
+
+	var total int64
+	for _, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += fileInfo.Size()
+	}
+
+	ts := newTS()
+	defer ts.close()
+
+	b.SetBytes(total)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			if _, err := ts.parsePackageInfo(path, "sha256"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	repo := repository{outputDir: "repo", srpmsDir: "repo/SRPMS", debugDir: "repo/debug/"}
+	if dirs := repo.outputDirs(); len(dirs) != 3 || dirs[2] != "repo/debug" {
+		t.Error("wrong output directories:", dirs)
+	}
+
+	cases := map[string]packageInfo{
+		"repo":       {rpmName: "openssl", rpmArch: "x86_64"},
+		"repo/SRPMS": {rpmName: "openssl", rpmArch: "src"},
+		"repo/debug": {rpmName: "openssl-debuginfo", rpmArch: "x86_64"},
+	}
+	for expected, p := range cases {
+		if dirs := repo.route(&p); len(dirs) != 1 || dirs[0] != expected {
+			t.Errorf("route(%s.%s) = %v, expected %s", p.rpmName, p.rpmArch, dirs, expected)
+		}
+	}
+
+	repo = repository{outputDir: "repo", srpmsDir: "repo"}
+	if dirs := repo.outputDirs(); len(dirs) != 1 {
+		t.Error("output directories should not repeat:", dirs)
+	}
+}
+
+func TestRouteArches(t *testing.T) {
+	repo := repository{
+		outputDir:    "out",
+		arches:       []string{"x86_64", "aarch64", "ppc64le"},
+		archLayout:   "{arch}/os",
+		compatArches: map[string][]string{"aarch64": {"armv7hl"}},
+	}
+	if dirs := repo.outputDirs(); len(dirs) != 3 || dirs[0] != "out/x86_64/os" {
+		t.Error("wrong output directories:", dirs)
+	}
+
+	cases := map[string][]string{
+		"x86_64":  {"out/x86_64/os"},
+		"i686":    {"out/x86_64/os"},
+		"armv7hl": {"out/aarch64/os"},
+		"noarch":  {"out/x86_64/os", "out/aarch64/os", "out/ppc64le/os"},
+		"src":     {"out/x86_64/os", "out/aarch64/os", "out/ppc64le/os"},
+		"s390x":   nil,
+	}
+	for arch, expected := range cases {
+		dirs := repo.route(&packageInfo{rpmName: "foo", rpmArch: arch})
+		if strings.Join(dirs, " ") != strings.Join(expected, " ") {
+			t.Errorf("route(%s) = %v, expected %v", arch, dirs, expected)
+		}
+	}
+
+	repo.srpmsDir = "out/SRPMS"
+	if dirs := repo.route(&packageInfo{rpmName: "foo", rpmArch: "src"}); len(dirs) != 1 || dirs[0] != "out/SRPMS" {
+		t.Error("source packages should go to the SRPMS repository:", dirs)
+	}
+}
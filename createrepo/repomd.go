@@ -0,0 +1,301 @@
+package createrepo
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	repomdXmlns    = "http://linux.duke.edu/metadata/repo"
+	repomdXmlnsRpm = "http://linux.duke.edu/metadata/rpm"
+)
+
+// repomd is the content of repodata/repomd.xml
+type repomd struct {
+	XMLName  xml.Name     `xml:"repomd"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	XmlnsRpm string       `xml:"xmlns:rpm,attr"`
+	Revision string       `xml:"revision"`
+	Data     []repomdData `xml:"data"`
+}
+
+type repomdChecksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type repomdLocation struct {
+	Href string `xml:"href,attr"`
+}
+
+// repomdData describes a metadata file such as primary_db
+type repomdData struct {
+	Type            string          `xml:"type,attr"`
+	Checksum        repomdChecksum  `xml:"checksum"`
+	OpenChecksum    *repomdChecksum `xml:"open-checksum,omitempty"`
+	Location        repomdLocation  `xml:"location"`
+	Timestamp       int64           `xml:"timestamp"`
+	Size            int64           `xml:"size"`
+	OpenSize        int64           `xml:"open-size,omitempty"`
+	DatabaseVersion int             `xml:"database_version,omitempty"`
+}
+
+// metadataTime returns the time recorded in repomd.xml: SOURCE_DATE_EPOCH if
+// it is set, for reproducible builds, and the current time otherwise
+func metadataTime() int64 {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		t, err := strconv.ParseInt(epoch, 10, 64)
+		if err == nil {
+			return t
+		}
+		log.Printf("ignoring invalid SOURCE_DATE_EPOCH %q\n", epoch)
+	}
+	return time.Now().Unix()
+}
+
+// newRepomd returns an empty repomd whose revision is the metadataTime
+func newRepomd() *repomd {
+	return &repomd{
+		Xmlns:    repomdXmlns,
+		XmlnsRpm: repomdXmlnsRpm,
+		Revision: strconv.FormatInt(metadataTime(), 10),
+	}
+}
+
+// readRepomd parses repomd.xml in repodataDir
+func readRepomd(repodataDir string) (*repomd, error) {
+	content, err := ioutil.ReadFile(filepath.Join(repodataDir, "repomd.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var md repomd
+	if err = xml.Unmarshal(content, &md); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid repomd.xml in %s: %s", repodataDir, err.Error()))
+	}
+	md.Xmlns = repomdXmlns
+	md.XmlnsRpm = repomdXmlnsRpm
+	for i := range md.Data {
+		data := &md.Data[i]
+		data.Checksum.Type = normalizeChecksumType(data.Checksum.Type)
+		if data.OpenChecksum != nil {
+			data.OpenChecksum.Type = normalizeChecksumType(data.OpenChecksum.Type)
+		}
+	}
+	return &md, nil
+}
+
+// write writes md as repomd.xml into repodataDir
+func (md *repomd) write(repodataDir string) error {
+	content, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	content = append([]byte(xml.Header), content...)
+	content = append(content, '\n')
+	return ioutil.WriteFile(filepath.Join(repodataDir, "repomd.xml"), content, 0644)
+}
+
+// find returns the data entry of the given type or nil
+func (md *repomd) find(mdType string) *repomdData {
+	for i := range md.Data {
+		if md.Data[i].Type == mdType {
+			return &md.Data[i]
+		}
+	}
+	return nil
+}
+
+// set adds data to md, replacing an entry of the same type
+func (md *repomd) set(data repomdData) {
+	if old := md.find(data.Type); old != nil {
+		*old = data
+		return
+	}
+	md.Data = append(md.Data, data)
+}
+
+// remove removes the entry of the given type and returns it, nil if there
+// is none.
+func (md *repomd) remove(mdType string) *repomdData {
+	for i, data := range md.Data {
+		if data.Type == mdType {
+			md.Data = append(md.Data[:i], md.Data[i+1:]...)
+			return &data
+		}
+	}
+	return nil
+}
+
+// checksumFile returns the checksum and the size of the file at path
+func checksumFile(path string, checksumType string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	return checksumReader(file, checksumType)
+}
+
+// checksumReader returns the checksum and the size of the content of r
+func checksumReader(r io.Reader, checksumType string) (string, int64, error) {
+	hash, err := newHash(checksumType)
+	if err != nil {
+		return "", 0, err
+	}
+
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+}
+
+// addMetadataFile compresses the file at path, which must be in
+// repodataDir, and returns its repomd entry.
+func addMetadataFile(path string, mdType string, checksumType string, compression string) (repomdData, error) {
+	data := repomdData{Type: mdType, Timestamp: metadataTime()}
+
+	openChecksum, openSize, err := checksumFile(path, checksumType)
+	if err != nil {
+		return data, err
+	}
+
+	compressed, err := compressFile(path, compression)
+	if err != nil {
+		return data, err
+	}
+
+	checksum, size, err := checksumFile(compressed, checksumType)
+	if err != nil {
+		return data, err
+	}
+
+	data.Checksum = repomdChecksum{checksumType, checksum}
+	data.Size = size
+	if compressed != path {
+		data.OpenChecksum = &repomdChecksum{checksumType, openChecksum}
+		data.OpenSize = openSize
+	}
+	data.Location.Href = "repodata/" + filepath.Base(compressed)
+	return data, nil
+}
+
+// openMetadataDB opens the sqlite database of the given type, e.g.
+// primary_db, of the repository in repoDir. Compressed databases are
+// decompressed into a temporary file first. The returned function closes
+// the database and removes the temporary file.
+func openMetadataDB(repoDir string, mdType string) (*sql.DB, func(), error) {
+	md, err := readRepomd(filepath.Join(repoDir, "repodata"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data := md.find(mdType)
+	if data == nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s has no %s", repoDir, mdType))
+	}
+
+	in, err := openDecompressed(filepath.Join(repoDir, filepath.FromSlash(data.Location.Href)))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile("", "createrepo-lite-"+mdType)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(tmp, in)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	db, err := sql.Open("sqlite3", tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	return db, func() {
+		db.Close()
+		os.Remove(tmp.Name())
+	}, nil
+}
+
+// AddMetadata copies the file at path into repodataDir and adds it to
+// repomd.xml as mdType, which defaults to the file name up to the first dot.
+// The file is compressed unless it already is.
+func AddMetadata(repodataDir string, path string, mdType string, checksumType string, compression string) error {
+	md, err := readRepomd(repodataDir)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	if mdType == "" {
+		mdType = strings.SplitN(name, ".", 2)[0]
+	}
+	if compressorOf(name).suffix != "" {
+		compression = "none"
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(repodataDir, name)
+	if err = ioutil.WriteFile(dst, content, 0644); err != nil {
+		return err
+	}
+
+	data, err := addMetadataFile(dst, mdType, checksumType, compression)
+	if err != nil {
+		return err
+	}
+	if old := md.find(mdType); old != nil && old.Location.Href != data.Location.Href {
+		os.Remove(filepath.Join(repodataDir, filepath.Base(old.Location.Href)))
+	}
+
+	md.set(data)
+	return md.write(repodataDir)
+}
+
+// RemoveMetadata removes the metadata named by nameOrType, a file name or a
+// type, from repodataDir and repomd.xml.
+func RemoveMetadata(repodataDir string, nameOrType string) error {
+	md, err := readRepomd(repodataDir)
+	if err != nil {
+		return err
+	}
+
+	mdType := nameOrType
+	for _, data := range md.Data {
+		if filepath.Base(data.Location.Href) == filepath.Base(nameOrType) {
+			mdType = data.Type
+		}
+	}
+
+	data := md.remove(mdType)
+	if data == nil {
+		return errors.New(fmt.Sprintf("no metadata %s in %s", nameOrType, repodataDir))
+	}
+	if err = os.Remove(filepath.Join(repodataDir, filepath.Base(data.Location.Href))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return md.write(repodataDir)
+}
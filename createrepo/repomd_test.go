@@ -0,0 +1,55 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModifyrepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repodataDir := filepath.Join(dir, "repodata")
+	if err = os.Mkdir(repodataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = newRepomd().write(repodataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	comps := filepath.Join(dir, "comps.xml")
+	if err = ioutil.WriteFile(comps, []byte("<comps/>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = AddMetadata(repodataDir, comps, "", "sha256", "gz"); err != nil {
+		t.Fatal("AddMetadata() failed:", err.Error())
+	}
+	md, err := readRepomd(repodataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := md.find("comps")
+	if data == nil {
+		t.Fatal("comps not found in repomd.xml")
+	}
+	shouldEqualStr(t, "data.Location.Href", data.Location.Href, "repodata/comps.xml.gz")
+	if data.OpenChecksum == nil || data.OpenSize != 9 {
+		t.Errorf("wrong open checksum/size: %+v", data)
+	}
+
+	if err = RemoveMetadata(repodataDir, "comps"); err != nil {
+		t.Fatal("RemoveMetadata() failed:", err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(repodataDir, "comps.xml.gz")); !os.IsNotExist(err) {
+		t.Error("comps.xml.gz should have been removed")
+	}
+	if md, _ = readRepomd(repodataDir); md.find("comps") != nil {
+		t.Error("comps should have been removed from repomd.xml")
+	}
+}
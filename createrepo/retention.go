@@ -0,0 +1,120 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "log"
+import "os"
+import "path/filepath"
+import "sort"
+import "time"
+
+type pruneAction int
+
+const (
+	// pruneNone leaves the pruned files alone, they are only missing from
+	// the repodata
+	pruneNone pruneAction = iota
+	// pruneMove moves the pruned files into another directory
+	pruneMove
+	// pruneDelete deletes the pruned files
+	pruneDelete
+)
+
+// retentionPolicy decides which builds of a package stay in the repository
+type retentionPolicy struct {
+	// keep is the number of the highest EVRs kept per name and arch
+	keep int
+	// maxAge keeps the builds younger than it
+	maxAge time.Duration
+	action pruneAction
+	// moveDir is where pruneMove puts the files, below their path relative
+	// to the package directory
+	moveDir string
+}
+
+// parsePruneAction returns the pruneAction named by action, which is one of
+// "none", "move" and "delete"
+func parsePruneAction(action string) (pruneAction, error) {
+	switch action {
+	case "none":
+		return pruneNone, nil
+	case "move":
+		return pruneMove, nil
+	case "delete":
+		return pruneDelete, nil
+	}
+	return pruneNone, errors.New(fmt.Sprintf("unknown prune action: %s", action))
+}
+
+func (r retentionPolicy) enabled() bool {
+	return r.keep > 0 || r.maxAge > 0
+}
+
+// apply splits packages into the kept and the pruned ones. A build is kept
+// if its EVR is one of the keep highest of its name and arch, or if it was
+// built less than maxAge before now. The highest EVR is always kept, so that
+// no package disappears because it has not been rebuilt for a while.
+func (r retentionPolicy) apply(packages []*packageInfo, now time.Time) ([]*packageInfo, []*packageInfo) {
+	groups := make(map[string][]*packageInfo)
+	for _, p := range packages {
+		key := p.rpmName + "." + p.rpmArch
+		groups[key] = append(groups[key], p)
+	}
+
+	var kept, pruned []*packageInfo
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			if rc := LabelCompare(group[i].nevra(), group[j].nevra()); rc != 0 {
+				return rc > 0
+			}
+			return group[i].path < group[j].path
+		})
+
+		rank := 0
+		for i, p := range group {
+			if i > 0 && LabelCompare(p.nevra(), group[i-1].nevra()) != 0 {
+				rank++
+			}
+
+			built := time.Unix(int64(p.rpmBuildTime), 0)
+			switch {
+			case rank == 0,
+				r.keep > 0 && rank < r.keep,
+				r.maxAge > 0 && now.Sub(built) < r.maxAge:
+				kept = append(kept, p)
+			default:
+				pruned = append(pruned, p)
+			}
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].path < kept[j].path })
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].path < pruned[j].path })
+	return kept, pruned
+}
+
+// prune moves or deletes the file of p according to the action. baseDir is
+// the package directory.
+func (r retentionPolicy) prune(p *packageInfo, baseDir string) error {
+	switch r.action {
+	case pruneDelete:
+		log.Printf("deleting %s\n", p.path)
+		return os.Remove(p.path)
+	case pruneMove:
+		base, err := filepath.Abs(baseDir)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p.path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(r.moveDir, rel)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		log.Printf("moving %s to %s\n", p.path, target)
+		return os.Rename(p.path, target)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	day := uint32(24 * 60 * 60)
+	build := func(name, evr, arch string, age uint32) *packageInfo {
+		n, err := ParseNEVRA(name + "-" + evr + "." + arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &packageInfo{
+			path:         "/repo/" + n.String() + ".rpm",
+			rpmName:      n.Name,
+			rpmEpoch:     n.Epoch,
+			rpmVersion:   n.Version,
+			rpmRelease:   n.Release,
+			rpmArch:      n.Arch,
+			rpmBuildTime: uint32(now.Unix()) - age*day,
+		}
+	}
+	packages := []*packageInfo{
+		build("foo", "1.0-1", "x86_64", 30),
+		build("foo", "1.0-10", "x86_64", 10),
+		build("foo", "1.0-2", "x86_64", 20),
+		build("foo", "1:0.9-1", "x86_64", 40),
+		build("foo", "1.0-1", "aarch64", 30),
+		build("bar", "2.0-1", "noarch", 100),
+	}
+
+	names := func(packages []*packageInfo) string {
+		var s []string
+		for _, p := range packages {
+			s = append(s, p.nevra().String())
+		}
+		return strings.Join(s, " ")
+	}
+
+	tests := []struct {
+		policy retentionPolicy
+		pruned string
+	}{
+		{retentionPolicy{keep: 2}, "foo-1.0-1.x86_64 foo-1.0-2.x86_64"},
+		{retentionPolicy{keep: 10}, ""},
+		{retentionPolicy{maxAge: 25 * 24 * time.Hour}, "foo-1.0-1.x86_64"},
+		{retentionPolicy{keep: 1, maxAge: 15 * 24 * time.Hour}, "foo-1.0-1.x86_64 foo-1.0-2.x86_64"},
+	}
+	for _, test := range tests {
+		kept, pruned := test.policy.apply(packages, now)
+		if names(pruned) != test.pruned {
+			t.Errorf("%+v pruned %q, expected %q", test.policy, names(pruned), test.pruned)
+		}
+		if len(kept)+len(pruned) != len(packages) {
+			t.Errorf("%+v lost packages: kept %q", test.policy, names(kept))
+		}
+	}
+}
+
+func TestGenerateDryRun(t *testing.T) {
+	dir := newTestRepo(t, "a/openssl.rpm", "b/openssl.rpm")
+	defer os.RemoveAll(dir)
+
+	// both copies have the same EVR, so both count as the newest build
+	g, err := NewGenerator(dir, WithRetention(1, 0), WithPrune("delete", ""), WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	if result.Indexed != 2 || len(result.Pruned) != 0 {
+		t.Errorf("expected 2 indexed and nothing pruned, got %+v", result)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "repodata")); !os.IsNotExist(err) {
+		t.Error("a dry run should not write repodata")
+	}
+
+	if _, err = NewGenerator(dir, WithPrune("move", "")); err == nil {
+		t.Error("moving without a directory should be rejected")
+	}
+	if _, err = NewGenerator(dir, WithPrune("shred", "")); err == nil {
+		t.Error("unknown prune action should be rejected")
+	}
+}
+
+func TestRetentionPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "repo", "sub", "foo.rpm")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err = ioutil.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := retentionPolicy{keep: 1, action: pruneMove, moveDir: filepath.Join(dir, "attic")}
+	if err = policy.prune(&packageInfo{path: path}, filepath.Join(dir, "repo")); err != nil {
+		t.Fatal("prune failed:", err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(dir, "attic", "sub", "foo.rpm")); err != nil {
+		t.Error("the package was not moved:", err.Error())
+	}
+
+	policy.action = pruneDelete
+	path = filepath.Join(dir, "attic", "sub", "foo.rpm")
+	if err = policy.prune(&packageInfo{path: path}, dir); err != nil {
+		t.Fatal("prune failed:", err.Error())
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the package was not deleted")
+	}
+}
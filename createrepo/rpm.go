@@ -0,0 +1,223 @@
+package createrepo
+
+// #cgo LDFLAGS: -lrpm -lrpmio
+// #include <rpm/rpmts.h>
+// #include <rpm/rpmlib.h>
+// #include <rpm/header.h>
+// #include <rpm/rpmio.h>
+// #include <rpm/rpmtag.h>
+// #include <stdlib.h>
+import "C"
+
+import "unsafe"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+
+type rpmts struct {
+	ts C.rpmts
+}
+
+// newTS allocates a rpmts object which is needed for most of RPM related functions
+func newTS() rpmts {
+	return rpmts{C.rpmtsCreate()}
+}
+
+// close deallocates the rpmts object allocated by newTS
+func (ts rpmts) close() {
+	C.rpmtsFree(ts.ts)
+}
+
+type rpmheader struct {
+	header      C.Header
+	signature   C.Header
+	path        string
+	startOffset uint64
+	endOffset   uint64
+}
+
+// openRPM open a RPM file and returns a rpmheader object where you could do various RPM related operations on.
+func (ts rpmts) openRPM(path string) (*rpmheader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ts.readRPM(path, file)
+}
+
+// readRPM reads the lead, signature and header of a RPM from r and returns a
+// rpmheader object for it. The payload is left unread in r.
+func (ts rpmts) readRPM(path string, r io.Reader) (*rpmheader, error) {
+	blob, err := readHeaderBlob(r)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Parse package '%s' failed: %s", path, err.Error()))
+	}
+
+	header := rpmheader{path: path, startOffset: blob.start, endOffset: blob.end}
+	header.header = importHeader(blob.data)
+	if header.header == nil {
+		return nil, errors.New("Parse package '" + path + "' failed!")
+	}
+	header.signature = importHeader(blob.signature)
+	if header.signature == nil {
+		header.close()
+		return nil, errors.New("Parse signature of package '" + path + "' failed!")
+	}
+	return &header, nil
+}
+
+// importHeader converts a header blob read from a RPM into a C.Header. It
+// returns nil if librpm rejects the blob.
+func importHeader(data []byte) C.Header {
+	cBlob := C.CBytes(data)
+	defer C.free(cBlob)
+
+	return C.headerImport(cBlob, C.uint(len(data)), C.HEADERIMPORT_COPY)
+}
+
+// close free the resources in a rpmheader object
+func (header *rpmheader) close() {
+	C.headerFree(header.header)
+	header.header = nil
+	if header.signature != nil {
+		C.headerFree(header.signature)
+		header.signature = nil
+	}
+}
+
+// legacySigTags maps tags which older packages only carry in the signature
+// header to their signature tag. rpmReadPackageFile() used to merge these into
+// the main header for us.
+var legacySigTags = map[string]C.rpmTagVal{
+	"archivesize":  C.RPMSIGTAG_PAYLOADSIZE,
+	"sha1header":   C.RPMSIGTAG_SHA1,
+	"sha256header": C.RPMSIGTAG_SHA256,
+}
+
+type rpmtag struct {
+	header C.Header
+	name   string
+	value  C.rpmTagVal
+}
+
+// getTag returns a rpmtag object for the given tag name
+func (header *rpmheader) getTag(tag string) (rpmtag, error) {
+	cTag := C.CString(tag)
+	defer C.free(unsafe.Pointer(cTag))
+
+	tagVal := C.rpmTagGetValue(cTag)
+	if tagVal == 0 {
+		return rpmtag{}, errors.New(fmt.Sprintf("unknown rpm tag: %s", tag))
+	}
+
+	if C.headerIsEntry(header.header, tagVal) == 0 {
+		if sigTag, ok := legacySigTags[tag]; ok && C.headerIsEntry(header.signature, sigTag) != 0 {
+			return rpmtag{header: header.signature, name: tag, value: sigTag}, nil
+		}
+	}
+
+	return rpmtag{header: header.header, name: tag, value: tagVal}, nil
+
+}
+
+// getString returns the value in the tag as String.
+func (tag rpmtag) getString() (string, error) {
+	cStr := C.headerGetString(tag.header, tag.value)
+	if cStr == nil {
+		return "", errors.New(fmt.Sprintf("failed to get value of tag(%s) as string.", tag.name))
+	}
+	return C.GoString(cStr), nil
+}
+
+// getNumber returns the value in the tag as a number
+func (tag rpmtag) getNumber() (uint64, error) {
+	var td C.struct_rpmtd_s
+
+	ret := C.headerGet(tag.header, tag.value, &td, C.HEADERGET_EXT)
+	if ret == 0 || C.rpmtdCount(&td) != 1 {
+		return 0, errors.New(fmt.Sprintf("not found tag(%s) in header.", tag.name))
+	}
+
+	return uint64(C.rpmtdGetNumber(&td)), nil
+}
+
+// getStrings returns the values of a string array tag, nil if the header
+// does not have the tag
+func (header *rpmheader) getStrings(tagName string) ([]string, error) {
+	tag, err := header.getTag(tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	var td C.struct_rpmtd_s
+	if C.headerGet(tag.header, tag.value, &td, C.HEADERGET_MINMEM|C.HEADERGET_EXT) == 0 {
+		return nil, nil
+	}
+	defer C.rpmtdFreeData(&td)
+
+	values := make([]string, 0, int(C.rpmtdCount(&td)))
+	for C.rpmtdNext(&td) >= 0 {
+		cStr := C.rpmtdGetString(&td)
+		if cStr == nil {
+			return nil, errors.New(fmt.Sprintf("failed to get value of tag(%s) as string array.", tag.name))
+		}
+		values = append(values, C.GoString(cStr))
+	}
+	return values, nil
+}
+
+// getNumbers returns the values of a number array tag, nil if the header
+// does not have the tag
+func (header *rpmheader) getNumbers(tagName string) ([]uint64, error) {
+	tag, err := header.getTag(tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	var td C.struct_rpmtd_s
+	if C.headerGet(tag.header, tag.value, &td, C.HEADERGET_MINMEM|C.HEADERGET_EXT) == 0 {
+		return nil, nil
+	}
+	defer C.rpmtdFreeData(&td)
+
+	values := make([]uint64, 0, int(C.rpmtdCount(&td)))
+	for C.rpmtdNext(&td) >= 0 {
+		values = append(values, uint64(C.rpmtdGetNumber(&td)))
+	}
+	return values, nil
+}
+
+// hasTag returns true if the RPM header has the given tag
+func (header *rpmheader) hasTag(tagName string) bool {
+	tag, err := header.getTag(tagName)
+	return err == nil && C.headerIsEntry(tag.header, tag.value) != 0
+}
+
+// getString returns the value of given tag in the RPM header as string
+func (header *rpmheader) getString(tagName string) (string, error) {
+	tag, err := header.getTag(tagName)
+	if err != nil {
+		return "", err
+	}
+
+	return tag.getString()
+}
+
+// getNumber returns the value of the given tag in the RPM header as a number
+func (header *rpmheader) getNumber(tagName string) (uint64, error) {
+	tag, err := header.getTag(tagName)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.getNumber()
+}
+
+// getHeaderRange return the byte range of the header in the RPM file as
+// (startOffset, endOffset, nil). It returns a non-nil error on errors
+func (header *rpmheader) getHeaderRange() (uint64, uint64, error) {
+	return header.startOffset, header.endOffset, nil
+}
@@ -1,4 +1,4 @@
-package main
+package createrepo
 
 import (
 	"testing"
@@ -10,7 +10,7 @@ func TestKnownRPMTags(t *testing.T) {
 
 	hdr, err := ts.openRPM("openssl.rpm")
 	if err != nil {
-		t.Fatal("openRPM() failed: %s", err.Error())
+		t.Fatalf("openRPM() failed: %s", err.Error())
 	}
 	defer hdr.close()
 
@@ -18,14 +18,14 @@ func TestKnownRPMTags(t *testing.T) {
 	if err != nil {
 		t.Error("getNumber(buildtime) failed:", err.Error())
 	} else if buildTime != 1421775236 {
-		t.Error("%buildtime has a wrong value:", buildTime)
+		t.Error("buildtime has a wrong value:", buildTime)
 	}
 
 	name, err := hdr.getString("name")
 	if err != nil {
 		t.Error("getString(name) failed:", err.Error())
 	} else if name != "openssl" {
-		t.Error("%name has a wrong value:", name)
+		t.Error("name has a wrong value:", name)
 	}
 }
 
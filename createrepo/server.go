@@ -0,0 +1,209 @@
+package createrepo
+
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "mime"
+import "net/http"
+import "os"
+import "path"
+import "path/filepath"
+import "strings"
+import "golang.org/x/net/context"
+
+// DefaultMaxUploadSize is the largest RPM a Server accepts by default
+const DefaultMaxUploadSize = 1 << 30
+
+// uploadPattern matches the temporary files of uploads, which are excluded
+// from the repodata, see WithUploads
+const uploadPattern = ".upload-*"
+
+// contentTypes are the content types of the files of a repository by
+// suffix, other files get the type of their extension
+var contentTypes = map[string]string{
+	".rpm":    "application/x-rpm",
+	".xml":    "application/xml",
+	".gz":     "application/gzip",
+	".bz2":    "application/x-bzip2",
+	".xz":     "application/x-xz",
+	".zst":    "application/zstd",
+	".zck":    "application/zchunk",
+	".sqlite": "application/vnd.sqlite3",
+	".yaml":   "application/yaml",
+}
+
+// Server serves a repository directory over HTTP, see NewServer
+type Server struct {
+	dir string
+	g   *Generator
+	// MaxUploadSize is the largest RPM accepted, DefaultMaxUploadSize
+	// unless changed
+	MaxUploadSize int64
+}
+
+// NewServer returns a Server of the files in dir. Files are served with
+// Range, ETag and Last-Modified support, and GET /healthz tells whether dir
+// has repodata. If g is not nil, RPMs may be uploaded by PUT or POST to
+// their path below dir, which must be the package directory of g: they are
+// validated, stored and followed by an Update. POST does not replace an
+// existing file. g must be created WithUploads.
+func NewServer(dir string, g *Generator) *Server {
+	return &Server{dir: dir, g: g, MaxUploadSize: DefaultMaxUploadSize}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	switch {
+	case urlPath == "/healthz" && (r.Method == "GET" || r.Method == "HEAD"):
+		s.serveHealth(w)
+	case r.Method == "GET" || r.Method == "HEAD":
+		s.serveFile(w, r, urlPath)
+	case (r.Method == "PUT" || r.Method == "POST") && s.g != nil:
+		s.serveUpload(w, r, urlPath)
+	default:
+		allow := "GET, HEAD"
+		if s.g != nil {
+			allow += ", PUT, POST"
+		}
+		w.Header().Set("Allow", allow)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// localPath returns the file of urlPath, an empty string for hidden files
+func (s *Server) localPath(urlPath string) string {
+	for _, name := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(name, ".") {
+			return ""
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(urlPath))
+}
+
+func (s *Server) serveHealth(w http.ResponseWriter) {
+	if _, err := readRepomd(filepath.Join(s.dir, "repodata")); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, urlPath string) {
+	localPath := s.localPath(urlPath)
+	if localPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType, ok := contentTypes[filepath.Ext(localPath)]
+	if !ok {
+		contentType = mime.TypeByExtension(filepath.Ext(localPath))
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// uploadResult is the response to an upload
+type uploadResult struct {
+	Package      string `json:"package"`
+	LocationHref string `json:"location_href"`
+	Checksum     string `json:"checksum"`
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, urlPath string) {
+	localPath := s.localPath(urlPath)
+	if localPath == "" || !looksLikeRPM(localPath) || isRepodataPath(urlPath) {
+		http.Error(w, "uploads must go to a path ending in .rpm outside of the repodata", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(localPath); err == nil && r.Method == "POST" {
+		http.Error(w, fmt.Sprintf("%s already exists", urlPath), http.StatusConflict)
+		return
+	}
+
+	info, err := s.receive(r, localPath, r.Method == "PUT")
+	if os.IsExist(err) {
+		http.Error(w, fmt.Sprintf("%s already exists", urlPath), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("rejected upload of %s: %s\n", urlPath, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("received %s: %s\n", urlPath, info.nevra())
+
+	// the upload is complete even if the client goes away now
+	if _, err = s.g.Update(context.Background()); err != nil {
+		http.Error(w, fmt.Sprintf("stored %s, but updating the repodata failed: %s", urlPath, err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploadResult{info.nevra().String(), strings.TrimPrefix(urlPath, "/"), info.checksum})
+}
+
+// receive stores the body of r at localPath once it is known to be a RPM.
+// Unless replace is set, it fails with an os.IsExist error if there is a
+// file at localPath.
+func (s *Server) receive(r *http.Request, localPath string, replace bool) (*packageInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(localPath), uploadPattern)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(r.Body, s.MaxUploadSize+1))
+	tmp.Close()
+	switch {
+	case err != nil:
+		return nil, err
+	case n > s.MaxUploadSize:
+		return nil, errors.New(fmt.Sprintf("larger than %d bytes", s.MaxUploadSize))
+	}
+
+	kind, err := detectPackage(tmp.Name())
+	switch {
+	case err != nil:
+		return nil, err
+	case kind == deltaPackage:
+		return nil, errors.New("delta RPMs are not indexed")
+	}
+	ts := newTS()
+	defer ts.close()
+	info, err := ts.parsePackageInfo(tmp.Name(), s.g.repo.checksumType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if !replace {
+		// unlike a rename, a link does not replace what another upload
+		// stored in the meantime
+		return info, os.Link(tmp.Name(), localPath)
+	}
+	return info, os.Rename(tmp.Name(), localPath)
+}
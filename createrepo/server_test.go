@@ -0,0 +1,106 @@
+package createrepo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestServer(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(dir, WithUploads())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewServer(dir, g))
+	defer ts.Close()
+
+	do := func(method string, path string, body []byte, header ...string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	expectStatus := func(resp *http.Response, status int) {
+		if resp.StatusCode != status {
+			t.Errorf("%s %s: status %d, expected %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status)
+		}
+	}
+
+	expectStatus(do("GET", "/healthz", nil), http.StatusServiceUnavailable)
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	expectStatus(do("GET", "/healthz", nil), http.StatusOK)
+
+	resp := do("GET", "/repodata/repomd.xml", nil)
+	expectStatus(resp, http.StatusOK)
+	shouldEqualStr(t, "Content-Type", resp.Header.Get("Content-Type"), "application/xml")
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Last-Modified") == "" {
+		t.Errorf("missing ETag or Last-Modified in %v", resp.Header)
+	}
+	expectStatus(do("GET", "/repodata/repomd.xml", nil, "If-None-Match", etag), http.StatusNotModified)
+
+	resp = do("GET", "/openssl.rpm", nil, "Range", "bytes=0-3")
+	expectStatus(resp, http.StatusPartialContent)
+	shouldEqualStr(t, "Content-Range", resp.Header.Get("Content-Range"), fmt.Sprintf("bytes 0-3/%d", len(content)))
+	shouldEqualStr(t, "Content-Type", resp.Header.Get("Content-Type"), "application/x-rpm")
+
+	expectStatus(do("GET", "/missing.rpm", nil), http.StatusNotFound)
+	expectStatus(do("GET", "/repodata", nil), http.StatusNotFound)
+	expectStatus(do("DELETE", "/openssl.rpm", nil), http.StatusMethodNotAllowed)
+
+	expectStatus(do("PUT", "/broken.rpm", []byte("broken")), http.StatusBadRequest)
+	expectStatus(do("PUT", "/repodata/x.rpm", content), http.StatusBadRequest)
+	expectStatus(do("PUT", "/x.txt", content), http.StatusBadRequest)
+	expectStatus(do("POST", "/openssl.rpm", content), http.StatusConflict)
+	if _, err = os.Stat(filepath.Join(dir, "broken.rpm")); !os.IsNotExist(err) {
+		t.Error("an invalid upload should not be stored")
+	}
+
+	expectStatus(do("PUT", "/sub/new.rpm", content), http.StatusCreated)
+	expectStatus(do("POST", "/sub/posted.rpm", content), http.StatusCreated)
+	expectStatus(do("POST", "/sub/posted.rpm", content), http.StatusConflict)
+	// a POST racing with another upload of the same path loses
+	post := httptest.NewRequest("POST", "/openssl.rpm", bytes.NewReader(content))
+	if _, err = NewServer(dir, g).receive(post, filepath.Join(dir, "openssl.rpm"), false); !os.IsExist(err) {
+		t.Errorf("receive() should not replace an existing file, got %v", err)
+	}
+	packages, err := loadPackages(dir, dir)
+	if err != nil || len(packages) != 3 {
+		t.Errorf("the upload should be indexed, got %d packages: %v", len(packages), err)
+	}
+
+	// read only without a Generator
+	ro := httptest.NewServer(NewServer(dir, nil))
+	defer ro.Close()
+	req, _ := http.NewRequest("PUT", ro.URL+"/other.rpm", bytes.NewReader(content))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expectStatus(resp, http.StatusMethodNotAllowed)
+}
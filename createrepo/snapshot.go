@@ -0,0 +1,270 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "time"
+import "golang.org/x/net/context"
+
+// snapshotLayout is the format of the time in the name of a snapshot
+const snapshotLayout = "20060102T150405Z"
+
+// Snapshot is a published view of a package directory, see PublishSnapshot
+type Snapshot struct {
+	// Name is the time the snapshot was published, in UTC, with a suffix
+	// if several were published in the same second
+	Name string
+	Time time.Time
+	// Path is the directory of the snapshot
+	Path string
+	// Latest is true for the snapshot the latest symlink points to
+	Latest bool
+}
+
+// snapshotsDir returns the directory of the snapshots below root
+func snapshotsDir(root string) string {
+	return filepath.Join(root, "snapshots")
+}
+
+// PublishSnapshot publishes the RPMs of dir as a new snapshot below root:
+// root/snapshots/<time> gets hard links to them and its own repodata,
+// generated with options, and root/latest is switched to it. The snapshot
+// only appears once it is complete and is never modified afterwards. The
+// repodata of the latest snapshot is reused for the RPMs which did not
+// change. dir and root must be on the same file system.
+func PublishSnapshot(ctx context.Context, root string, dir string, options ...Option) (*Snapshot, *Result, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.MkdirAll(snapshotsDir(root), 0755); err != nil {
+		return nil, nil, err
+	}
+	staging, err := ioutil.TempDir(snapshotsDir(root), ".publish-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(staging)
+	if err = os.Chmod(staging, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	if err = linkPackages(ctx, dir, staging); err != nil {
+		return nil, nil, err
+	}
+	// the files of the latest snapshot are the same inodes, so Update
+	// takes over their packages
+	latest := filepath.Join(root, "latest", "repodata")
+	if err = linkTree(latest, filepath.Join(staging, "repodata")); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	g, err := NewGenerator(staging, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := g.Update(ctx)
+	if err != nil {
+		return nil, result, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	name := now.Format(snapshotLayout)
+	for i := 2; ; i++ {
+		err = os.Rename(staging, filepath.Join(snapshotsDir(root), name))
+		// another snapshot of the same second is in the way
+		if !os.IsExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", now.Format(snapshotLayout), i)
+	}
+	if err != nil {
+		return nil, result, err
+	}
+	if err = setLatest(root, name); err != nil {
+		return nil, result, err
+	}
+	return &Snapshot{name, now, filepath.Join(snapshotsDir(root), name), true}, result, nil
+}
+
+// linkPackages hard links the RPMs below dir into the same place below dst
+func linkPackages(ctx context.Context, dir string, dst string) error {
+	repo := repository{baseDir: dir, followSymlinks: true}
+	files, errs := findRPMFiles(ctx, &repo)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for f := range errs {
+			if err == nil {
+				err = f
+			}
+		}
+		done <- err
+	}()
+
+	var err error
+	for path := range files {
+		if err != nil {
+			continue
+		}
+		rel, relErr := filepath.Rel(dir, path)
+		if relErr != nil {
+			err = relErr
+			continue
+		}
+		target := filepath.Join(dst, rel)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+			err = os.Link(path, target)
+		}
+	}
+	if walkErr := <-done; err == nil {
+		err = walkErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// linkTree hard links the files below src into the same place below dst
+func linkTree(src string, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		return os.Link(path, filepath.Join(dst, rel))
+	})
+}
+
+// setLatest points root/latest to the snapshot name. The symlink is replaced
+// by a rename, so readers see either the old or the new snapshot.
+func setLatest(root string, name string) error {
+	tmp := filepath.Join(root, ".latest")
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join("snapshots", name), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(root, "latest")); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots below root, oldest first
+func ListSnapshots(root string) ([]Snapshot, error) {
+	entries, err := ioutil.ReadDir(snapshotsDir(root))
+	if err != nil {
+		return nil, err
+	}
+	latest, _ := os.Readlink(filepath.Join(root, "latest"))
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		s := Snapshot{
+			Name:   entry.Name(),
+			Time:   entry.ModTime().UTC(),
+			Path:   filepath.Join(snapshotsDir(root), entry.Name()),
+			Latest: filepath.Base(latest) == entry.Name(),
+		}
+		if t, err := time.Parse(snapshotLayout, strings.SplitN(s.Name, "-", 2)[0]); err == nil {
+			s.Time = t
+		}
+		snapshots = append(snapshots, s)
+	}
+	// by time, then by suffix: -10 comes after -9
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return a.Name < b.Name
+	})
+	return snapshots, nil
+}
+
+// Rollback points root/latest to the snapshot name, or to the one before
+// the current latest snapshot if name is empty
+func Rollback(root string, name string) (*Snapshot, error) {
+	snapshots, err := ListSnapshots(root)
+	if err != nil {
+		return nil, err
+	}
+
+	target := -1
+	for i, s := range snapshots {
+		switch {
+		case name == "" && s.Latest:
+			target = i - 1
+		case name != "" && s.Name == name:
+			target = i
+		}
+	}
+	switch {
+	case target < 0 && name == "":
+		return nil, errors.New("no snapshot before the latest one")
+	case target < 0:
+		return nil, errors.New(fmt.Sprintf("no snapshot %s", name))
+	}
+
+	s := snapshots[target]
+	if _, err = readRepomd(filepath.Join(s.Path, "repodata")); err != nil {
+		return nil, err
+	}
+	if err = setLatest(root, s.Name); err != nil {
+		return nil, err
+	}
+	s.Latest = true
+	return &s, nil
+}
+
+// GCSnapshots removes the snapshots below root except the keep newest ones
+// and those younger than maxAge. A zero value disables the respective
+// limit, and the snapshot latest points to is always kept. Nothing is
+// removed if dryRun is set. It returns the snapshots removed.
+func GCSnapshots(root string, keep int, maxAge time.Duration, dryRun bool) ([]Snapshot, error) {
+	if keep < 0 || maxAge < 0 {
+		return nil, errors.New(fmt.Sprintf("invalid retention: keep %d, max age %s", keep, maxAge))
+	}
+	snapshots, err := ListSnapshots(root)
+	if err != nil || keep == 0 && maxAge == 0 {
+		return nil, err
+	}
+
+	now := time.Now()
+	var removed []Snapshot
+	for i, s := range snapshots {
+		newest := keep > 0 && i >= len(snapshots)-keep
+		young := maxAge > 0 && now.Sub(s.Time) < maxAge
+		if s.Latest || newest || young {
+			continue
+		}
+		if !dryRun {
+			if err = os.RemoveAll(s.Path); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
@@ -0,0 +1,100 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSnapshots(t *testing.T) {
+	pool := newTestRepo(t, "a/openssl.rpm")
+	defer os.RemoveAll(pool)
+	root, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []*Snapshot
+	for i, name := range []string{"b/openssl.rpm", "c/openssl.rpm", ""} {
+		s, result, err := PublishSnapshot(context.Background(), root, pool)
+		if err != nil {
+			t.Fatal("PublishSnapshot() failed:", err.Error())
+		}
+		if result.Indexed != i+1 || result.Reused != i {
+			t.Errorf("snapshot %d: indexed %d, reused %d", i, result.Indexed, result.Reused)
+		}
+		published = append(published, s)
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(pool, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(pool, "a", "openssl.rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked, err := os.Stat(filepath.Join(published[0].Path, "a", "openssl.rpm"))
+	if err != nil || !os.SameFile(info, linked) {
+		t.Error("the snapshot should hard link the packages of the pool")
+	}
+	packages, err := loadPackages(filepath.Join(root, "latest"), filepath.Join(root, "latest"))
+	if err != nil || len(packages) != 3 {
+		t.Fatalf("latest should have 3 packages: %v", err)
+	}
+
+	snapshots, err := ListSnapshots(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || !snapshots[2].Latest || snapshots[2].Name != published[2].Name {
+		t.Fatalf("wrong snapshots %+v", snapshots)
+	}
+
+	s, err := Rollback(root, "")
+	if err != nil {
+		t.Fatal("Rollback() failed:", err.Error())
+	}
+	shouldEqualStr(t, "rolled back", s.Name, published[1].Name)
+	if s, err = Rollback(root, published[0].Name); err != nil {
+		t.Fatal("Rollback() failed:", err.Error())
+	}
+	if _, err = Rollback(root, "19700101T000000Z"); err == nil {
+		t.Error("rolling back to an unknown snapshot should fail")
+	}
+
+	// the newest one is kept by the retention, the oldest as it is latest
+	removed, err := GCSnapshots(root, 1, time.Hour*24*365*100, true)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("all snapshots are young, got %+v %v", removed, err)
+	}
+	removed, err = GCSnapshots(root, 1, 0, false)
+	if err != nil {
+		t.Fatal("GCSnapshots() failed:", err.Error())
+	}
+	if len(removed) != 1 || removed[0].Name != published[1].Name {
+		t.Fatalf("expected only the middle snapshot to be removed, got %+v", removed)
+	}
+	if _, err = os.Stat(published[1].Path); !os.IsNotExist(err) {
+		t.Error("the removed snapshot is still there")
+	}
+	if _, err = os.Stat(filepath.Join(pool, "b", "openssl.rpm")); err != nil {
+		t.Error("removing a snapshot must not touch the pool")
+	}
+}
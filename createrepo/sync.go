@@ -0,0 +1,431 @@
+package createrepo
+
+import "errors"
+import "fmt"
+import "io"
+import "net/http"
+import "net/url"
+import "os"
+import "path"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+import "golang.org/x/net/context"
+
+// partSuffix is appended to the files being downloaded by Sync, which
+// resumes them on the next run
+const partSuffix = ".part"
+
+// syncStaging is the directory below the mirror where Sync keeps the
+// repodata of the source until it is complete
+const syncStaging = ".sync"
+
+// SyncOptions configure Sync
+type SyncOptions struct {
+	// Delete removes the RPMs of the mirror which are gone from the source
+	Delete bool
+	// Metadata is "copy", the default, to take over the repodata of the
+	// source, or "regenerate" to run Update on the mirror instead
+	Metadata string
+	// Workers is the number of concurrent downloads, 1 by default
+	Workers int
+	// Verify hashes every package of the mirror instead of trusting those
+	// with the size and time of the repodata
+	Verify bool
+	// Client does the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// SyncResult is the outcome of Sync
+type SyncResult struct {
+	// Downloaded are the locations of the packages fetched from the source
+	Downloaded []string
+	// UpToDate is the number of packages which were already mirrored
+	UpToDate int
+	// Deleted are the RPMs removed because they are gone from the source
+	Deleted  []string
+	Failures []Failure
+	// Fetched is the number of bytes downloaded
+	Fetched int64
+}
+
+// WriteSummary prints the deleted RPMs and the failed packages to w
+func (r *SyncResult) WriteSummary(w io.Writer) {
+	for _, path := range r.Deleted {
+		fmt.Fprintf(w, "deleted %s\n", path)
+	}
+	(&Result{Failures: r.Failures}).WriteSummary(w)
+}
+
+// syncSource reads the files of the repository mirrored by Sync
+type syncSource struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// newSyncSource returns the syncSource of source, a directory, a file://
+// URL or a HTTP URL
+func newSyncSource(source string, client *http.Client) (*syncSource, error) {
+	base, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	switch base.Scheme {
+	case "":
+		dir, err := filepath.Abs(source)
+		if err != nil {
+			return nil, err
+		}
+		base = &url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}
+	case "file", "http", "https":
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported source: %s", source))
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	return &syncSource{base, client}, nil
+}
+
+// resolve returns the URL of href, relative to base or absolute
+func (s *syncSource) resolve(base string, href string) (*url.URL, error) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return s.base.ResolveReference(ref), nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	return u.ResolveReference(ref), nil
+}
+
+// open returns the content of u from offset on, or from the start if the
+// server does not support Range requests. It also returns the offset the
+// content starts at.
+func (s *syncSource) open(ctx context.Context, u *url.URL, offset int64) (io.ReadCloser, int64, error) {
+	if u.Scheme == "file" {
+		file, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return file, offset, nil
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		return resp.Body, offset, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, 0, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is not shorter than the file, start over
+		resp.Body.Close()
+		return s.open(ctx, u, 0)
+	}
+	resp.Body.Close()
+	return nil, 0, errors.New(fmt.Sprintf("GET %s: %s", u, resp.Status))
+}
+
+// download stores u at dst if its checksum matches. An existing dst.part is
+// resumed. It returns the number of bytes fetched.
+func (s *syncSource) download(ctx context.Context, u *url.URL, dst string, checksumType string, checksum string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	part := dst + partSuffix
+	file, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	body, start, err := s.open(ctx, u, offset)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	defer body.Close()
+	if start != offset {
+		if err = file.Truncate(start); err == nil {
+			_, err = file.Seek(start, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return 0, err
+		}
+	}
+	n, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// keep what we have for the next run
+		return n, err
+	}
+
+	actual, _, err := checksumFile(part, checksumType)
+	if err != nil {
+		return n, err
+	}
+	if actual != checksum {
+		os.Remove(part)
+		return n, errors.New(fmt.Sprintf("%s %s, the repodata has %s", checksumType, actual, checksum))
+	}
+	return n, os.Rename(part, dst)
+}
+
+// fetchRepodata downloads repomd.xml and the files it lists into
+// dir/repodata, checking their checksums
+func (s *syncSource) fetchRepodata(ctx context.Context, dir string) error {
+	repodataDir := filepath.Join(dir, "repodata")
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	u, err := s.resolve("", "repodata/repomd.xml")
+	if err != nil {
+		return err
+	}
+	body, _, err := s.open(ctx, u, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err = os.MkdirAll(repodataDir, 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(filepath.Join(repodataDir, "repomd.xml"))
+	if err == nil {
+		_, err = io.Copy(out, body)
+		out.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	md, err := readRepomd(repodataDir)
+	if err != nil {
+		return err
+	}
+	for _, data := range md.Data {
+		href := path.Clean(data.Location.Href)
+		if !strings.HasPrefix(href, "repodata/") {
+			return errors.New(fmt.Sprintf("%s is outside of the repodata", data.Location.Href))
+		}
+		if u, err = s.resolve("", href); err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(href))
+		if _, err = s.download(ctx, u, dst, data.Checksum.Type, data.Checksum.Value); err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", href, err.Error()))
+		}
+	}
+	return nil
+}
+
+// isMirrored returns true if the file of p is already in place. Like the
+// cache of parsePackage, a file with the size and time of the repodata is
+// trusted unless verify is set, the others are hashed.
+func isMirrored(p *packageInfo, verify bool) bool {
+	info, err := os.Stat(p.path)
+	if err != nil || uint64(info.Size()) != p.fileSize {
+		return false
+	}
+	if !verify && uint32(info.ModTime().Unix()) == p.fileTime {
+		return true
+	}
+	checksum, _, err := checksumFile(p.path, p.checksumType)
+	if err != nil || checksum != p.checksum {
+		return false
+	}
+	// trusted by the next run
+	setMirrored(p)
+	return true
+}
+
+// setMirrored gives the file of p, whose checksum matched, the time of the
+// repodata, see isMirrored
+func setMirrored(p *packageInfo) error {
+	t := time.Unix(int64(p.fileTime), 0)
+	return os.Chtimes(p.path, t, t)
+}
+
+// Sync mirrors the repository at source, a directory, file:// or HTTP URL,
+// into dir. The packages of its repodata which are missing from dir or
+// differ are downloaded and checked against their pkgId, and get the time
+// of the repodata so that the next run can trust them; interrupted
+// downloads are resumed by the next run. Packages with a location_base are
+// fetched from there. Once all packages are in place, the repodata of the
+// source is copied, or regenerated, into dir. If some package failed, the
+// result lists it and the copied repodata is left as it was.
+func Sync(ctx context.Context, source string, dir string, options SyncOptions) (*SyncResult, error) {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	switch options.Metadata {
+	case "":
+		options.Metadata = "copy"
+	case "copy", "regenerate":
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown metadata mode: %s", options.Metadata))
+	}
+	src, err := newSyncSource(source, options.Client)
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	staging := filepath.Join(dir, syncStaging)
+	defer os.RemoveAll(staging)
+	if err = src.fetchRepodata(ctx, staging); err != nil {
+		return nil, err
+	}
+	packages, err := loadPackages(staging, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SyncResult{}
+	failures := make([]error, len(packages))
+	for i, p := range packages {
+		// the path of such a package is outside of dir, it is never touched
+		rel := path.Clean(p.locationHref)
+		if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			failures[i] = errors.New("location outside of the repository")
+		}
+	}
+	downloaded := make([]bool, len(packages))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+	wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				p := packages[i]
+				if isMirrored(p, options.Verify) {
+					os.Remove(p.path + partSuffix)
+					continue
+				}
+				base := ""
+				if p.locationBase != nil {
+					base = *p.locationBase
+				}
+				u, err := src.resolve(base, path.Clean(p.locationHref))
+				if err != nil {
+					failures[i] = err
+					continue
+				}
+				n, err := src.download(ctx, u, p.path, p.checksumType, p.checksum)
+				if err == nil {
+					err = setMirrored(p)
+				}
+				failures[i], downloaded[i] = err, err == nil
+				mu.Lock()
+				result.Fetched += n
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range packages {
+		if ctx.Err() != nil {
+			break
+		}
+		if failures[i] == nil {
+			next <- i
+		}
+	}
+	close(next)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, errors.New(fmt.Sprintf("syncing %s canceled: %s", source, ctx.Err().Error()))
+	}
+
+	wanted := make(map[string]bool)
+	for i, p := range packages {
+		wanted[p.path] = true
+		switch {
+		case failures[i] != nil:
+			result.Failures = append(result.Failures, Failure{p.locationHref, failures[i]})
+		case downloaded[i]:
+			result.Downloaded = append(result.Downloaded, p.locationHref)
+		default:
+			result.UpToDate++
+		}
+	}
+
+	if options.Delete {
+		if err = deleteUnwanted(ctx, dir, wanted, &result); err != nil {
+			return &result, err
+		}
+	}
+
+	if options.Metadata == "regenerate" {
+		g, err := NewGenerator(dir, WithExcludes("*"+partSuffix, syncStaging))
+		if err != nil {
+			return &result, err
+		}
+		_, err = g.Update(ctx)
+		return &result, err
+	}
+	if len(result.Failures) != 0 {
+		return &result, nil
+	}
+	return &result, replaceDir(filepath.Join(staging, "repodata"), filepath.Join(dir, "repodata"))
+}
+
+// deleteUnwanted removes the RPMs below dir which are not wanted
+func deleteUnwanted(ctx context.Context, dir string, wanted map[string]bool, result *SyncResult) error {
+	repo := repository{baseDir: dir, excludes: []string{"*" + partSuffix, syncStaging}}
+	files, errs := findRPMFiles(ctx, &repo)
+	go func() {
+		// unreadable paths are left alone
+		for range errs {
+		}
+	}()
+
+	var unwanted []string
+	for path := range files {
+		if !wanted[path] {
+			unwanted = append(unwanted, path)
+		}
+	}
+	sort.Strings(unwanted)
+	for _, path := range unwanted {
+		if err := os.Remove(path); err != nil {
+			result.Failures = append(result.Failures, Failure{path, err})
+			continue
+		}
+		result.Deleted = append(result.Deleted, path)
+	}
+	return ctx.Err()
+}
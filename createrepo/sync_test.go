@@ -0,0 +1,206 @@
+package createrepo
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSync(t *testing.T) {
+	source := newTestRepo(t, "Packages/a.rpm", "Packages/b.rpm")
+	defer os.RemoveAll(source)
+	g, err := NewGenerator(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	var mu sync.Mutex
+	var ranges []string
+	files := http.FileServer(http.Dir(source))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			mu.Lock()
+			ranges = append(ranges, r.URL.Path+" "+r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	mirror := newTestRepo(t, "stale.rpm")
+	defer os.RemoveAll(mirror)
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an interrupted download of a.rpm
+	part := filepath.Join(mirror, "Packages", "a.rpm"+partSuffix)
+	if err = os.MkdirAll(filepath.Dir(part), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(part, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Sync(context.Background(), ts.URL, mirror, SyncOptions{Delete: true, Workers: 2})
+	if err != nil {
+		t.Fatal("Sync() failed:", err.Error())
+	}
+	if len(result.Failures) != 0 {
+		t.Fatalf("unexpected failures %+v", result.Failures)
+	}
+	shouldEqualStr(t, "downloaded", strings.Join(result.Downloaded, " "), "Packages/a.rpm Packages/b.rpm")
+	shouldEqualStr(t, "deleted", strings.Join(result.Deleted, " "), filepath.Join(mirror, "stale.rpm"))
+	shouldEqualStr(t, "resumed", strings.Join(ranges, " "), "/Packages/a.rpm bytes=1000-")
+	if result.Fetched != int64(2*len(content)-1000) {
+		t.Errorf("fetched %d bytes", result.Fetched)
+	}
+
+	report, err := Verify(context.Background(), mirror, mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Packages != 2 {
+		t.Errorf("the mirror does not verify: %+v", report)
+	}
+
+	// a second run has nothing to do, and a local source works as well
+	if err = ioutil.WriteFile(part, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Sync(context.Background(), source, mirror, SyncOptions{Metadata: "regenerate"})
+	if err != nil {
+		t.Fatal("Sync() failed:", err.Error())
+	}
+	if result.UpToDate != 2 || len(result.Downloaded) != 0 {
+		t.Errorf("expected the packages to be up to date, got %+v", result)
+	}
+	for _, leftover := range []string{syncStaging, "Packages/a.rpm" + partSuffix} {
+		if _, err = os.Stat(filepath.Join(mirror, leftover)); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone", leftover)
+		}
+	}
+
+	// a package with the size and time of the repodata is trusted, unless
+	// Verify is set
+	mirrored := filepath.Join(mirror, "Packages", "a.rpm")
+	info, err := os.Stat(mirrored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte{}, content...)
+	damaged[len(damaged)-1] ^= 0xff
+	if err = ioutil.WriteFile(mirrored, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(mirrored, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if result, err = Sync(context.Background(), source, mirror, SyncOptions{}); err != nil || result.UpToDate != 2 {
+		t.Errorf("expected the packages to be trusted, got %+v %v", result, err)
+	}
+	result, err = Sync(context.Background(), source, mirror, SyncOptions{Verify: true})
+	if err != nil {
+		t.Fatal("Sync() failed:", err.Error())
+	}
+	shouldEqualStr(t, "downloaded with Verify", strings.Join(result.Downloaded, " "), "Packages/a.rpm")
+
+	// a corrupted package is reported and its download discarded
+	if err = os.Remove(filepath.Join(mirror, "Packages", "b.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte{}, content...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if err = ioutil.WriteFile(filepath.Join(source, "Packages", "b.rpm"), corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Sync(context.Background(), "file://"+source, mirror, SyncOptions{})
+	if err != nil {
+		t.Fatal("Sync() failed:", err.Error())
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Path != "Packages/b.rpm" {
+		t.Fatalf("expected b.rpm to fail, got %+v", result)
+	}
+	if _, err = os.Stat(filepath.Join(mirror, "Packages", "b.rpm"+partSuffix)); !os.IsNotExist(err) {
+		t.Error("the corrupted download should be removed")
+	}
+}
+
+func TestSyncOutsideLocation(t *testing.T) {
+	source := newTestRepo(t, "a.rpm")
+	defer os.RemoveAll(source)
+	g, err := NewGenerator(source, WithCompression("none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+	md, err := readRepomd(filepath.Join(source, "repodata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := md.find("primary_db")
+	primary := filepath.Join(source, filepath.FromSlash(data.Location.Href))
+	db, err := sql.Open("sqlite3", primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE packages SET location_href = '../outside.rpm'")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Checksum.Value, data.Size, err = checksumFile(primary, data.Checksum.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err = md.write(filepath.Join(source, "repodata")); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(parent, "outside.rpm")
+	for _, path := range []string{outside, outside + partSuffix} {
+		if err = ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Unix(1000000000, 0)
+	if err = os.Chtimes(outside, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Sync(context.Background(), source, filepath.Join(parent, "mirror"), SyncOptions{})
+	if err != nil {
+		t.Fatal("Sync() failed:", err.Error())
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Path != "../outside.rpm" {
+		t.Errorf("expected the package to be rejected, got %+v", result)
+	}
+	if _, err = os.Stat(outside + partSuffix); err != nil {
+		t.Error("the files outside of the mirror should be left alone:", err.Error())
+	}
+	if info, err := os.Stat(outside); err != nil || !info.ModTime().Equal(old) {
+		t.Error("the files outside of the mirror should not be touched")
+	}
+}
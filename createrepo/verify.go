@@ -0,0 +1,214 @@
+package createrepo
+
+import "fmt"
+import "net/url"
+import "os"
+import "path/filepath"
+import "sort"
+import "golang.org/x/net/context"
+
+// kinds of Problem found by Verify
+const (
+	// ProblemMetadataMissing is a metadata file listed in repomd.xml which
+	// cannot be read
+	ProblemMetadataMissing = "metadata-missing"
+	// ProblemMetadataSize and ProblemMetadataChecksum are metadata files
+	// which differ from repomd.xml, compressed or not
+	ProblemMetadataSize     = "metadata-size"
+	ProblemMetadataChecksum = "metadata-checksum"
+	// ProblemPrimaryUnreadable means the packages could not be checked
+	// because the primary database is missing or broken
+	ProblemPrimaryUnreadable = "primary-unreadable"
+	// ProblemPackageMissing, ProblemPackageSize and ProblemPackageChecksum
+	// are packages of the primary database which are not on disk as
+	// recorded
+	ProblemPackageMissing  = "package-missing"
+	ProblemPackageSize     = "package-size"
+	ProblemPackageChecksum = "package-checksum"
+	// ProblemPackageUnindexed is a RPM on disk which is not in the
+	// primary database
+	ProblemPackageUnindexed = "package-unindexed"
+	// ProblemUnreadable is a path below the package directory which could
+	// not be read while looking for unindexed RPMs
+	ProblemUnreadable = "unreadable"
+	// ProblemPoolStray is a file in a package pool which is not named like
+	// an object, see Pool.Verify
+	ProblemPoolStray = "pool-stray"
+)
+
+// Problem is an inconsistency found by Verify
+type Problem struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// VerifyReport is the outcome of Verify
+type VerifyReport struct {
+	// Metadata and Packages are the number of files checked
+	Metadata int `json:"metadata"`
+	Packages int `json:"packages"`
+	// Remote is the number of packages with a location_base which is not a
+	// file:// URL, they are not checked
+	Remote   int       `json:"remote,omitempty"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK returns true if no problem was found
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) add(kind string, path string, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{kind, path, fmt.Sprintf(format, args...)})
+}
+
+// Verify checks the repodata of repoDir against the files on disk: the
+// metadata files against repomd.xml, the packages of the primary database
+// against their size and checksum, and the RPMs in baseDir, which are the
+// packages the location_hrefs are relative to, against the primary
+// database. Packages with a file:// location_base are looked for there,
+// those with another location_base are only counted. It returns an error
+// only if repomd.xml cannot be read.
+func Verify(ctx context.Context, repoDir string, baseDir string) (*VerifyReport, error) {
+	md, err := readRepomd(filepath.Join(repoDir, "repodata"))
+	if err != nil {
+		return nil, err
+	}
+
+	report := VerifyReport{Problems: []Problem{}}
+	for _, data := range md.Data {
+		if ctx.Err() != nil {
+			return &report, ctx.Err()
+		}
+		report.Metadata++
+		verifyMetadataFile(&report, filepath.Join(repoDir, filepath.FromSlash(data.Location.Href)), data)
+	}
+
+	if err = verifyPackages(ctx, &report, repoDir, baseDir); err != nil {
+		return &report, err
+	}
+	return &report, nil
+}
+
+// verifyMetadataFile compares the file at path to its repomd.xml entry
+func verifyMetadataFile(report *VerifyReport, path string, data repomdData) {
+	checksum, size, err := checksumFile(path, data.Checksum.Type)
+	switch {
+	case err != nil:
+		report.add(ProblemMetadataMissing, path, "%s", err.Error())
+		return
+	case size != data.Size:
+		report.add(ProblemMetadataSize, path, "size %d, repomd.xml has %d", size, data.Size)
+		return
+	case checksum != data.Checksum.Value:
+		report.add(ProblemMetadataChecksum, path, "%s %s, repomd.xml has %s", data.Checksum.Type, checksum, data.Checksum.Value)
+		return
+	case data.OpenChecksum == nil:
+		return
+	}
+
+	in, err := openDecompressed(path)
+	if err != nil {
+		report.add(ProblemMetadataChecksum, path, "cannot decompress: %s", err.Error())
+		return
+	}
+	defer in.Close()
+
+	checksum, size, err = checksumReader(in, data.OpenChecksum.Type)
+	switch {
+	case err != nil:
+		report.add(ProblemMetadataChecksum, path, "cannot decompress: %s", err.Error())
+	case data.OpenSize != 0 && size != data.OpenSize:
+		report.add(ProblemMetadataSize, path, "uncompressed size %d, repomd.xml has %d", size, data.OpenSize)
+	case checksum != data.OpenChecksum.Value:
+		report.add(ProblemMetadataChecksum, path, "uncompressed %s %s, repomd.xml has %s", data.OpenChecksum.Type, checksum, data.OpenChecksum.Value)
+	}
+}
+
+// verifyPackages checks the packages of the primary database of repoDir
+// and looks for RPMs in baseDir which are not in it
+func verifyPackages(ctx context.Context, report *VerifyReport, repoDir string, baseDir string) error {
+	packages, err := loadPackages(repoDir, baseDir)
+	if err != nil {
+		report.add(ProblemPrimaryUnreadable, repoDir, "%s", err.Error())
+		return nil
+	}
+
+	indexed := make(map[string]bool)
+	for _, p := range packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		path, ok := p.localPath()
+		if !ok {
+			report.Remote++
+			continue
+		}
+		report.Packages++
+		p.path = path
+		indexed[p.path] = true
+		verifyPackage(report, p)
+	}
+
+	repo := repository{baseDir: baseDir, followSymlinks: true}
+	files, errs := findRPMFiles(ctx, &repo)
+	done := make(chan []failure)
+	go func() {
+		var failures []failure
+		for f := range errs {
+			failures = append(failures, f)
+		}
+		done <- failures
+	}()
+
+	var unindexed []string
+	for path := range files {
+		if !indexed[path] {
+			unindexed = append(unindexed, path)
+		}
+	}
+	for _, f := range <-done {
+		report.add(ProblemUnreadable, f.path, "%s", f.err.Error())
+	}
+	sort.Strings(unindexed)
+	for _, path := range unindexed {
+		report.add(ProblemPackageUnindexed, path, "not in the primary database")
+	}
+	return ctx.Err()
+}
+
+// localPath returns the path of the file of p, relative to its location_base
+// if that is a file:// URL, as in merged repositories. It returns false if
+// the location_base is any other URL.
+func (p *packageInfo) localPath() (string, bool) {
+	if p.locationBase == nil || *p.locationBase == "" {
+		return p.path, true
+	}
+	u, err := url.Parse(*p.locationBase)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(p.locationHref)), true
+}
+
+// verifyPackage compares the file of p to its size and checksum
+func verifyPackage(report *VerifyReport, p *packageInfo) {
+	info, err := os.Stat(p.path)
+	switch {
+	case err != nil:
+		report.add(ProblemPackageMissing, p.path, "%s", err.Error())
+		return
+	case uint64(info.Size()) != p.fileSize:
+		report.add(ProblemPackageSize, p.path, "size %d, primary has %d", info.Size(), p.fileSize)
+		return
+	}
+
+	checksum, _, err := checksumFile(p.path, p.checksumType)
+	switch {
+	case err != nil:
+		report.add(ProblemPackageMissing, p.path, "%s", err.Error())
+	case checksum != p.checksum:
+		report.add(ProblemPackageChecksum, p.path, "%s %s, primary has %s", p.checksumType, checksum, p.checksum)
+	}
+}
@@ -0,0 +1,144 @@
+package createrepo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestVerify(t *testing.T) {
+	dir := newTestRepo(t, "a.rpm", "b.rpm", "c.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	report, err := Verify(context.Background(), dir, dir)
+	if err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	if !report.OK() || report.Metadata != 3 || report.Packages != 3 {
+		t.Errorf("fresh repodata should verify, got %+v", report)
+	}
+
+	// flip a byte of a.rpm, remove b.rpm and add an unindexed d.rpm
+	content, err := ioutil.ReadFile(filepath.Join(dir, "a.rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Rename(filepath.Join(dir, "c.rpm"), filepath.Join(dir, "d.rpm"))
+	content[len(content)-1] ^= 0xff
+	ioutil.WriteFile(filepath.Join(dir, "a.rpm"), content, 0644)
+	os.Remove(filepath.Join(dir, "b.rpm"))
+
+	report, err = Verify(context.Background(), dir, dir)
+	if err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	expected := []Problem{
+		{Kind: ProblemPackageChecksum, Path: filepath.Join(dir, "a.rpm")},
+		{Kind: ProblemPackageMissing, Path: filepath.Join(dir, "b.rpm")},
+		{Kind: ProblemPackageMissing, Path: filepath.Join(dir, "c.rpm")},
+		{Kind: ProblemPackageUnindexed, Path: filepath.Join(dir, "d.rpm")},
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %+v", len(expected), report.Problems)
+	}
+	for i, p := range report.Problems {
+		shouldEqualStr(t, "problem kind", p.Kind, expected[i].Kind)
+		shouldEqualStr(t, "problem path", p.Path, expected[i].Path)
+	}
+
+	// truncate the primary database
+	primary := filepath.Join(dir, "repodata", "primary.sqlite.gz")
+	if err = os.Truncate(primary, 10); err != nil {
+		t.Fatal(err)
+	}
+	report, err = Verify(context.Background(), dir, dir)
+	if err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	if len(report.Problems) < 2 || report.Problems[0].Kind != ProblemMetadataSize || report.Problems[1].Kind != ProblemPrimaryUnreadable {
+		t.Errorf("broken primary database should be reported, got %+v", report.Problems)
+	}
+
+	if _, err = Verify(context.Background(), filepath.Join(dir, "none"), dir); err == nil {
+		t.Error("Verify() should fail without repomd.xml")
+	}
+}
+
+func TestVerifyMerged(t *testing.T) {
+	a := newMergeSource(t, "openssl.rpm")
+	defer os.RemoveAll(a)
+	b := newMergeSource(t, "Packages/openssl.rpm")
+	defer os.RemoveAll(b)
+	out, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	sources := []MergeSource{{Dir: a}, {Dir: b, BaseURL: "http://example.com/b/"}}
+	if _, err = Merge(context.Background(), out, sources, MergeOptions{Policy: "all"}); err != nil {
+		t.Fatal("Merge() failed:", err.Error())
+	}
+
+	report, err := Verify(context.Background(), out, out)
+	if err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	if !report.OK() || report.Packages != 1 || report.Remote != 1 {
+		t.Errorf("the merged repository should verify, got %+v", report)
+	}
+
+	if err = os.Remove(filepath.Join(a, "openssl.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = Verify(context.Background(), out, out); err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemPackageMissing || report.Problems[0].Path != filepath.Join(a, "openssl.rpm") {
+		t.Errorf("expected the RPM of the source to be missing, got %+v", report)
+	}
+}
+
+func TestVerifyShaChecksumType(t *testing.T) {
+	dir := newTestRepo(t, "a.rpm")
+	defer os.RemoveAll(dir)
+
+	g, err := NewGenerator(dir, WithChecksum("sha1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	// as written by yum-era createrepo
+	path := filepath.Join(dir, "repodata", "repomd.xml")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte(`type="sha1"`), []byte(`type="sha"`), -1)
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(context.Background(), dir, dir)
+	if err != nil {
+		t.Fatal("Verify() failed:", err.Error())
+	}
+	if !report.OK() || report.Metadata != 3 || report.Packages != 1 {
+		t.Errorf("the sha checksums should verify, got %+v", report)
+	}
+}
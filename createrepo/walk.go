@@ -0,0 +1,83 @@
+package createrepo
+
+import "log"
+import "os"
+import "path/filepath"
+import "sort"
+
+// walk is like filepath.Walk, except that symbolic links below root are
+// followed if followSymlinks is set and skipped otherwise. Every directory is
+// visited once even if several symlinks lead to it, so symlink loops are
+// harmless.
+func walk(root string, followSymlinks bool, fn filepath.WalkFunc) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = walkPath(root, info, followSymlinks, make(map[string]bool), fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkPath(path string, info os.FileInfo, followSymlinks bool, visited map[string]bool, fn filepath.WalkFunc) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !followSymlinks {
+			log.Printf("skipped symlink %s\n", path)
+			return nil
+		}
+
+		target, err := os.Stat(path)
+		if err != nil {
+			return fn(path, info, err)
+		}
+		info = target
+	}
+
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	if visited[realPath] {
+		return nil
+	}
+	visited[realPath] = true
+
+	if err = fn(path, info, nil); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return fn(path, info, err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err != nil {
+			if err = fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		err = walkPath(filename, fileInfo, followSymlinks, visited, fn)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func walkedFiles(t *testing.T, root string, followSymlinks bool) []string {
+	var files []string
+	err := walk(root, followSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("walk() failed:", err.Error())
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pool := filepath.Join(dir, "pool")
+	if err = os.Mkdir(pool, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(pool, "a.rpm"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a link to the file, one to its directory and a loop back to the root
+	for link, target := range map[string]string{"b.rpm": "pool/a.rpm", "linked": "pool", "pool/loop": ".."} {
+		if err = os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	followed := walkedFiles(t, dir, true)
+	expected := []string{"b.rpm", "linked/a.rpm"}
+	if len(followed) != len(expected) || followed[0] != expected[0] || followed[1] != expected[1] {
+		t.Errorf("walk() following symlinks found %v, expected %v", followed, expected)
+	}
+
+	skipped := walkedFiles(t, dir, false)
+	if len(skipped) != 1 || skipped[0] != "pool/a.rpm" {
+		t.Errorf("walk() skipping symlinks found %v", skipped)
+	}
+}
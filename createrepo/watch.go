@@ -0,0 +1,129 @@
+package createrepo
+
+import "errors"
+import "log"
+import "path/filepath"
+import "strings"
+import "time"
+import "golang.org/x/net/context"
+
+// watchEvent is a change of a file below the watched directory
+type watchEvent struct {
+	path string
+	// partial is true while the file is being written, i.e. it was created
+	// by a writer or modified but not closed yet
+	partial bool
+}
+
+// watcher reports the changes below a directory, see newWatcher
+type watcher interface {
+	events() <-chan watchEvent
+	errors() <-chan error
+	close() error
+}
+
+// isRepodataPath returns true if path, relative to the package directory,
+// is in metadata written by createrepo-lite itself
+func isRepodataPath(rel string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch name {
+		case "repodata", ".repodata", "repodata.old":
+			return true
+		}
+	}
+	return false
+}
+
+// escapeGlob returns a glob pattern matching only s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Watch runs Update after RPMs are added, removed or replaced below the
+// package directory, until ctx is done. An update starts once nothing
+// changed for the debounce duration. Files still being written are left out
+// until they are closed or renamed into place. published, if not nil, is
+// called with the outcome of every update.
+func (g *Generator) Watch(ctx context.Context, debounce time.Duration, published func(*Result, error)) error {
+	if g.repo.pkglist != nil {
+		return errors.New("cannot watch a package list")
+	}
+	baseDir, err := filepath.Abs(g.repo.baseDir)
+	if err != nil {
+		return err
+	}
+
+	w, err := newWatcher(baseDir)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	log.Printf("watching %s\n", baseDir)
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	partial := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-w.errors():
+			return err
+		case e := <-w.events():
+			rel, err := filepath.Rel(baseDir, e.path)
+			if err != nil || isRepodataPath(rel) || isExcluded(baseDir, e.path, g.repo.excludes) {
+				continue
+			}
+			if e.partial {
+				partial[e.path] = true
+				continue
+			}
+			delete(partial, e.path)
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(debounce)
+		case <-timer.C:
+			result, err := g.publish(ctx, baseDir, partial)
+			if published != nil {
+				published(result, err)
+			}
+		}
+	}
+}
+
+// publish runs Update without the partially written files
+func (g *Generator) publish(ctx context.Context, baseDir string, partial map[string]bool) (*Result, error) {
+	g.running.Lock()
+	defer g.running.Unlock()
+
+	excludes := g.repo.excludes
+	defer func() { g.repo.excludes = excludes }()
+	g.repo.excludes = append([]string{}, excludes...)
+	for path := range partial {
+		if rel, err := filepath.Rel(baseDir, path); err == nil {
+			g.repo.excludes = append(g.repo.excludes, escapeGlob(rel))
+		}
+	}
+
+	start := time.Now()
+	result, err := g.update(ctx)
+	if err != nil {
+		log.Printf("publishing %s failed: %s\n", strings.Join(g.repo.outputDirs(), ", "), err.Error())
+		return result, err
+	}
+	log.Printf("published %s: %d package(s), %d reused, %d failed, %d still being written, in %s\n",
+		strings.Join(g.repo.outputDirs(), ", "), result.Indexed, result.Reused, len(result.Failures), len(partial), time.Since(start))
+	return result, nil
+}
@@ -0,0 +1,178 @@
+//go:build linux
+// +build linux
+
+package createrepo
+
+import "bytes"
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "sync"
+import "syscall"
+import "unsafe"
+
+// inotifyMask selects the events telling that a file is complete, closed
+// after writing or renamed, or gone, plus the creations and modifications
+// of files still being written
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE | syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE_SELF
+
+// inotifyWatcher watches a directory tree with inotify, adding the
+// directories created in it
+type inotifyWatcher struct {
+	root string
+	file *os.File
+	fd   int
+	mu   sync.Mutex
+	// dirs are the watched directories by watch descriptor
+	dirs   map[int32]string
+	evs    chan watchEvent
+	errs   chan error
+	closed chan struct{}
+}
+
+// newWatcher watches the directory tree dir
+func newWatcher(dir string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &inotifyWatcher{
+		root: dir,
+		// a non-blocking file is read through the runtime poller, so that
+		// close interrupts the reader
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		dirs:   make(map[int32]string),
+		evs:    make(chan watchEvent),
+		errs:   make(chan error, 1),
+		closed: make(chan struct{}),
+	}
+	if err = w.addTree(dir); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) events() <-chan watchEvent {
+	return w.evs
+}
+
+func (w *inotifyWatcher) errors() <-chan error {
+	return w.errs
+}
+
+func (w *inotifyWatcher) close() error {
+	close(w.closed)
+	return w.file.Close()
+}
+
+// addTree watches dir and the directories below it, except the repodata
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			// gone or unreadable, nothing to watch
+			return nil
+		case !info.IsDir():
+			return nil
+		case path != dir && isRepodataPath(info.Name()):
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return errors.New(fmt.Sprintf("watching %s: %s", path, err.Error()))
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// isBeingWritten tells whether the file just created at path may still be
+// written. Symlinks and further hard links of a file are complete as soon as
+// they appear, and only get IN_CREATE, no IN_CLOSE_WRITE.
+func isBeingWritten(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// gone already, IN_DELETE follows
+		return false
+	}
+	return info.Mode().IsRegular() && hardLinks(info) < 2
+}
+
+// read turns the inotify events into watchEvents until the watcher is
+// closed
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.closed:
+			default:
+				w.errs <- errors.New(fmt.Sprintf("reading inotify events: %s", err.Error()))
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(raw.Len)], "\x00"))
+			offset = nameStart + int(raw.Len)
+
+			if !w.handle(raw.Wd, raw.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle sends the watchEvent of an inotify event, it returns false if the
+// watcher is closed
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) bool {
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+	}
+	w.mu.Unlock()
+
+	e := watchEvent{}
+	switch {
+	case mask&syscall.IN_Q_OVERFLOW != 0:
+		// events were lost, so anything may have changed
+		e.path = w.root
+	case !ok || name == "":
+		return true
+	case mask&syscall.IN_ISDIR != 0:
+		path := filepath.Join(dir, name)
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !isRepodataPath(name) {
+			if err := w.addTree(path); err != nil {
+				select {
+				case w.errs <- err:
+				default:
+				}
+			}
+		}
+		e.path = path
+	default:
+		e.path = filepath.Join(dir, name)
+		e.partial = mask&syscall.IN_MODIFY != 0 || mask&syscall.IN_CREATE != 0 && isBeingWritten(e.path)
+	}
+
+	select {
+	case w.evs <- e:
+		return true
+	case <-w.closed:
+		return false
+	}
+}
@@ -0,0 +1,105 @@
+package createrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWatch(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+	content, err := ioutil.ReadFile("openssl.rpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Generate(context.Background()); err != nil {
+		t.Fatal("Generate() failed:", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan *Result, 16)
+	done := make(chan error)
+	go func() {
+		done <- g.Watch(ctx, 50*time.Millisecond, func(result *Result, err error) {
+			if err != nil {
+				t.Error("update failed:", err.Error())
+			}
+			results <- result
+		})
+	}()
+	// let the watches be set up
+	time.Sleep(200 * time.Millisecond)
+
+	waitFor := func(indexed int) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case result := <-results:
+				if len(result.Failures) != 0 {
+					t.Fatalf("unexpected failures %+v", result.Failures)
+				}
+				if result.Indexed == indexed {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no update with %d packages", indexed)
+			}
+		}
+	}
+
+	partial, err := os.Create(filepath.Join(dir, "partial.rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer partial.Close()
+	if _, err = partial.Write(content[:1024]); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempFile("", "createrepo-lite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Write(content)
+	tmp.Close()
+	if err = os.Rename(tmp.Name(), filepath.Join(dir, "copy.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(2)
+
+	if _, err = partial.Write(content[1024:]); err != nil {
+		t.Fatal(err)
+	}
+	partial.Close()
+	waitFor(3)
+
+	// links only get IN_CREATE, and are complete right away
+	linked := filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"-linked.rpm")
+	if err = ioutil.WriteFile(linked, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(linked)
+	if err = os.Link(linked, filepath.Join(dir, "hard.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(4)
+	if err = os.Symlink(linked, filepath.Join(dir, "soft.rpm")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(5)
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Error("Watch() failed:", err.Error())
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package createrepo
+
+import "errors"
+
+// newWatcher fails, watching needs inotify
+func newWatcher(dir string) (watcher, error) {
+	return nil, errors.New("watching directories is only supported on linux")
+}
@@ -0,0 +1,274 @@
+package createrepo
+
+import "encoding/xml"
+import "errors"
+import "fmt"
+import "io"
+import "path/filepath"
+import "sort"
+
+// xmlVersion is the <version> of a package in the XML metadata
+type xmlVersion struct {
+	Epoch   string `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+}
+
+// xmlEntry is a <rpm:entry> of the dependencies in primary.xml
+type xmlEntry struct {
+	Name    string `xml:"name,attr"`
+	Flags   string `xml:"flags,attr"`
+	Epoch   string `xml:"epoch,attr"`
+	Version string `xml:"ver,attr"`
+	Release string `xml:"rel,attr"`
+	Pre     string `xml:"pre,attr"`
+}
+
+func (e xmlEntry) dependency() dependency {
+	return dependency{
+		name:    e.Name,
+		flags:   e.Flags,
+		epoch:   e.Epoch,
+		version: e.Version,
+		release: e.Release,
+		pre:     e.Pre == "1" || e.Pre == "true",
+	}
+}
+
+// xmlFile is a <file> of primary.xml or filelists.xml
+type xmlFile struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:",chardata"`
+}
+
+func (f xmlFile) packageFile() packageFile {
+	if f.Type == "" {
+		return packageFile{f.Name, "file"}
+	}
+	return packageFile{f.Name, f.Type}
+}
+
+// xmlPrimaryPackage is a <package> of primary.xml
+type xmlPrimaryPackage struct {
+	Name     string     `xml:"name"`
+	Arch     string     `xml:"arch"`
+	Version  xmlVersion `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Summary     string  `xml:"summary"`
+	Description string  `xml:"description"`
+	Packager    *string `xml:"packager"`
+	URL         *string `xml:"url"`
+	Time        struct {
+		File  uint32 `xml:"file,attr"`
+		Build uint32 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   uint64 `xml:"package,attr"`
+		Installed uint64 `xml:"installed,attr"`
+		Archive   uint64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location struct {
+		Base *string `xml:"base,attr"`
+		Href string  `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		License     *string `xml:"license"`
+		Vendor      *string `xml:"vendor"`
+		Group       *string `xml:"group"`
+		BuildHost   *string `xml:"buildhost"`
+		SourceRPM   *string `xml:"sourcerpm"`
+		HeaderRange struct {
+			Start uint64 `xml:"start,attr"`
+			End   uint64 `xml:"end,attr"`
+		} `xml:"header-range"`
+		Provides  []xmlEntry `xml:"provides>entry"`
+		Requires  []xmlEntry `xml:"requires>entry"`
+		Conflicts []xmlEntry `xml:"conflicts>entry"`
+		Obsoletes []xmlEntry `xml:"obsoletes>entry"`
+		Files     []xmlFile  `xml:"file"`
+	} `xml:"format"`
+}
+
+// xmlFilelistsPackage is a <package> of filelists.xml
+type xmlFilelistsPackage struct {
+	PkgID string    `xml:"pkgid,attr"`
+	Files []xmlFile `xml:"file"`
+}
+
+// xmlOtherPackage is a <package> of other.xml
+type xmlOtherPackage struct {
+	PkgID     string `xml:"pkgid,attr"`
+	Changelog []struct {
+		Author string `xml:"author,attr"`
+		Date   uint32 `xml:"date,attr"`
+		Text   string `xml:",chardata"`
+	} `xml:"changelog"`
+}
+
+// nonEmpty returns s, or nil if it is empty like the elements createrepo
+// writes for missing tags
+func nonEmpty(s *string) *string {
+	if s == nil || *s == "" {
+		return nil
+	}
+	return s
+}
+
+func (x *xmlPrimaryPackage) packageInfo(baseDir string) *packageInfo {
+	p := packageInfo{
+		path:           filepath.Join(baseDir, filepath.FromSlash(x.Location.Href)),
+		checksum:       x.Checksum.Value,
+		checksumType:   x.Checksum.Type,
+		fileTime:       x.Time.File,
+		fileSize:       x.Size.Package,
+		headerStart:    x.Format.HeaderRange.Start,
+		headerEnd:      x.Format.HeaderRange.End,
+		rpmName:        x.Name,
+		rpmArch:        x.Arch,
+		rpmVersion:     x.Version.Version,
+		rpmEpoch:       x.Version.Epoch,
+		rpmRelease:     x.Version.Release,
+		rpmSummary:     x.Summary,
+		rpmDescription: x.Description,
+		rpmUrl:         nonEmpty(x.URL),
+		rpmBuildTime:   x.Time.Build,
+		rpmLicense:     nonEmpty(x.Format.License),
+		rpmVendor:      nonEmpty(x.Format.Vendor),
+		rpmGroup:       nonEmpty(x.Format.Group),
+		rpmBuildHost:   nonEmpty(x.Format.BuildHost),
+		rpmSourceRpm:   nonEmpty(x.Format.SourceRPM),
+		rpmPackager:    nonEmpty(x.Packager),
+		rpmInstallSize: x.Size.Installed,
+		rpmArchiveSize: x.Size.Archive,
+		locationBase:   nonEmpty(x.Location.Base),
+		locationHref:   x.Location.Href,
+	}
+	p.checksumType = normalizeChecksumType(p.checksumType)
+	if p.rpmEpoch == "" {
+		p.rpmEpoch = "0"
+	}
+
+	deps := []struct {
+		entries []xmlEntry
+		dst     *[]dependency
+	}{
+		{x.Format.Provides, &p.provides},
+		{x.Format.Requires, &p.requires},
+		{x.Format.Conflicts, &p.conflicts},
+		{x.Format.Obsoletes, &p.obsoletes},
+	}
+	for _, d := range deps {
+		for _, e := range d.entries {
+			*d.dst = append(*d.dst, e.dependency())
+		}
+	}
+	// only the primary files, until filelists.xml is read
+	for _, f := range x.Format.Files {
+		p.files = append(p.files, f.packageFile())
+	}
+	return &p
+}
+
+// decodeXMLPackages decodes every <package> element of the metadata file of
+// the given type in the repository dir with fn
+func decodeXMLPackages(dir string, md *repomd, mdType string, fn func(d *xml.Decoder, start *xml.StartElement) error) error {
+	data := md.find(mdType)
+	if data == nil {
+		return errors.New(fmt.Sprintf("%s has no %s", dir, mdType))
+	}
+	path := filepath.Join(dir, filepath.FromSlash(data.Location.Href))
+	in, err := openDecompressed(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	d := xml.NewDecoder(in)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		if err = fn(d, &start); err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
+		}
+	}
+}
+
+// loadXMLPackages reads the packages of primary.xml, filelists.xml and
+// other.xml in dir, compressed or not, as written by createrepo and
+// createrepo_c
+func loadXMLPackages(dir string, md *repomd, baseDir string) ([]*packageInfo, error) {
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*packageInfo
+	byID := make(map[string][]*packageInfo)
+	err = decodeXMLPackages(dir, md, "primary", func(d *xml.Decoder, start *xml.StartElement) error {
+		var x xmlPrimaryPackage
+		if err := d.DecodeElement(&x, start); err != nil {
+			return err
+		}
+		p := x.packageInfo(baseDir)
+		p.pkgKey = pkgKey(len(packages))
+		packages = append(packages, p)
+		byID[p.checksum] = append(byID[p.checksum], p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = decodeXMLPackages(dir, md, "filelists", func(d *xml.Decoder, start *xml.StartElement) error {
+		var x xmlFilelistsPackage
+		if err := d.DecodeElement(&x, start); err != nil {
+			return err
+		}
+		var files []packageFile
+		for _, f := range x.Files {
+			files = append(files, f.packageFile())
+		}
+		sortFiles(files)
+		for _, p := range byID[x.PkgID] {
+			p.files = files
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = decodeXMLPackages(dir, md, "other", func(d *xml.Decoder, start *xml.StartElement) error {
+		var x xmlOtherPackage
+		if err := d.DecodeElement(&x, start); err != nil {
+			return err
+		}
+		var changelogs []changelog
+		for _, c := range x.Changelog {
+			changelogs = append(changelogs, changelog{c.Author, c.Date, c.Text})
+		}
+		// createrepo writes the oldest entry first
+		sort.SliceStable(changelogs, func(i, j int) bool { return changelogs[i].date > changelogs[j].date })
+		for _, p := range byID[x.PkgID] {
+			p.changelogs = changelogs
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
@@ -0,0 +1,137 @@
+package createrepo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// writeXMLRepodata writes repodata like createrepo_c --no-database for
+// openssl.rpm in dir
+func writeXMLRepodata(t *testing.T, dir string) {
+	ts := newTS()
+	defer ts.close()
+	p, err := ts.parsePackageInfo(filepath.Join(dir, "openssl.rpm"), "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"primary": fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="1">
+<package type="rpm">
+  <name>openssl</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.0.1e" rel="30.el6_6.5"/>
+  <checksum type="sha256" pkgid="YES">%s</checksum>
+  <summary>A general purpose cryptography library with TLS implementation</summary>
+  <description>The OpenSSL toolkit</description>
+  <packager>CentOS BuildSystem &lt;http://bugs.centos.org&gt;</packager>
+  <url>http://www.openssl.org/</url>
+  <time file="%d" build="%d"/>
+  <size package="%d" installed="%d" archive="%d"/>
+  <location href="openssl.rpm"/>
+  <format>
+    <rpm:license>OpenSSL</rpm:license>
+    <rpm:vendor/>
+    <rpm:header-range start="%d" end="%d"/>
+    <rpm:provides>
+      <rpm:entry name="openssl" flags="EQ" epoch="0" ver="1.0.1e" rel="30.el6_6.5"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="/bin/sh" pre="1"/>
+      <rpm:entry name="ca-certificates" flags="GE" epoch="0" ver="2008" rel="5"/>
+    </rpm:requires>
+    <file>/etc/pki/tls/openssl.cnf</file>
+  </format>
+</package>
+</metadata>
+`, p.checksum, p.fileTime, p.rpmBuildTime, p.fileSize, p.rpmInstallSize, p.rpmArchiveSize, p.headerStart, p.headerEnd),
+		"filelists": fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="1">
+<package pkgid="%s" name="openssl" arch="x86_64">
+  <version epoch="0" ver="1.0.1e" rel="30.el6_6.5"/>
+  <file type="dir">/etc/pki/tls</file>
+  <file>/etc/pki/tls/openssl.cnf</file>
+</package>
+</filelists>
+`, p.checksum),
+		"other": fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<otherdata xmlns="http://linux.duke.edu/metadata/other" packages="1">
+<package pkgid="%s" name="openssl" arch="x86_64">
+  <version epoch="0" ver="1.0.1e" rel="30.el6_6.5"/>
+  <changelog author="old" date="100">- first</changelog>
+  <changelog author="new" date="200">- second</changelog>
+</package>
+</otherdata>
+`, p.checksum),
+	}
+
+	repodataDir := filepath.Join(dir, "repodata")
+	if err = os.MkdirAll(repodataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = newRepomd().write(repodataDir); err != nil {
+		t.Fatal(err)
+	}
+	for mdType, content := range files {
+		path := filepath.Join(dir, mdType+".xml")
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = AddMetadata(repodataDir, path, mdType, "sha256", "xz"); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path)
+	}
+}
+
+func TestLoadXMLPackages(t *testing.T) {
+	dir := newTestRepo(t, "openssl.rpm")
+	defer os.RemoveAll(dir)
+	writeXMLRepodata(t, dir)
+
+	packages, err := loadPackages(dir, dir)
+	if err != nil {
+		t.Fatal("loadPackages failed:", err.Error())
+	}
+	if len(packages) != 1 {
+		t.Fatalf("expected 1 package, got %d", len(packages))
+	}
+	p := packages[0]
+	shouldEqualStr(t, "nevra", p.nevra().String(), "openssl-0:1.0.1e-30.el6_6.5.x86_64")
+	shouldEqualStr(t, "path", p.path, filepath.Join(dir, "openssl.rpm"))
+	shouldBeValidAndEqualStr(t, "packager", p.rpmPackager, "CentOS BuildSystem <http://bugs.centos.org>")
+	if p.rpmVendor != nil {
+		t.Error("an empty vendor should be nil")
+	}
+	if len(p.requires) != 2 || !p.requires[0].pre || p.requires[1].String() != "ca-certificates >= 2008-5" {
+		t.Errorf("wrong requires %+v", p.requires)
+	}
+	if len(p.files) != 2 || p.files[0].typ != "dir" {
+		t.Errorf("the files should come from filelists.xml, got %+v", p.files)
+	}
+	if len(p.changelogs) != 2 || p.changelogs[0].author != "new" {
+		t.Errorf("the changelog should be newest first, got %+v", p.changelogs)
+	}
+
+	// the package is carried over into the sqlite repodata
+	g, err := NewGenerator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := g.Update(context.Background())
+	if err != nil {
+		t.Fatal("Update() failed:", err.Error())
+	}
+	if result.Indexed != 1 || result.Reused != 1 {
+		t.Errorf("the package should be reused, got %+v", result)
+	}
+	if packages, err = loadPackages(dir, dir); err != nil || len(packages[0].requires) != 2 {
+		t.Errorf("expected the imported package in the new repodata, got %v", err)
+	}
+}